@@ -0,0 +1,223 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Policies of AsyncConfig when the queue is full.
+const (
+	AsyncBlock      = "block"
+	AsyncDropNewest = "dropnewest"
+	AsyncDropLowest = "droplowest"
+)
+
+const defaultAsyncQueueSize = 1024
+
+// AsyncConfig moves writing to the outputs off the logging goroutines. Entries
+// are encoded by the caller, queued, and written by a background goroutine,
+// so a slow disk or pipe doesn't stall the code that logs. Sync, and entries
+// above ErrorLevel, wait for the queue to be written.
+type AsyncConfig struct {
+	// QueueSize is the number of entries the queue holds, 1024 when zero.
+	QueueSize int `json:"queueSize" yaml:"queueSize"`
+
+	// Policy is what happens to an entry when the queue is full: "block"
+	// (default) waits for room, "dropnewest" drops the entry and "droplowest"
+	// drops the oldest queued entry with the lowest level, or the entry
+	// itself when its level is lower than all of the queued ones.
+	Policy string `json:"policy" yaml:"policy"`
+}
+
+// asyncDropped counts the entries dropped by full queues, per level.
+var asyncDropped [FatalLevel - DebugLevel + 1]atomic.Uint64
+
+// AsyncDropped returns how many entries full async queues have dropped per
+// level since the process started, across all loggers.
+func AsyncDropped() map[Level]uint64 {
+	dropped := make(map[Level]uint64, len(asyncDropped))
+	for i := range asyncDropped {
+		dropped[DebugLevel+Level(i)] = asyncDropped[i].Load()
+	}
+	return dropped
+}
+
+func (a *AsyncConfig) validate() []error {
+	if a == nil {
+		return nil
+	}
+	var errs []error
+	if a.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("async: negative queue size %d", a.QueueSize))
+	}
+	switch a.Policy {
+	case "", AsyncBlock, AsyncDropNewest, AsyncDropLowest:
+	default:
+		errs = append(errs, fmt.Errorf("async: not a valid policy: %q", a.Policy))
+	}
+	return errs
+}
+
+func (a *AsyncConfig) clone() *AsyncConfig {
+	if a == nil {
+		return nil
+	}
+	cloned := *a
+	return &cloned
+}
+
+// async returns the async config of c, creating it when async was disabled.
+func (c *Config) async() *AsyncConfig {
+	if c.Async == nil {
+		c.Async = &AsyncConfig{}
+	}
+	return c.Async
+}
+
+// asyncWriter is an entryWriter queueing the entries and writing them to out
+// from a background goroutine.
+type asyncWriter struct {
+	out    entryWriter
+	size   int
+	policy string
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	written  *sync.Cond
+	queue    []outputEntry
+	spare    []outputEntry
+	queued   uint64 // entries queued so far
+	done     uint64 // entries written or dropped from the queue so far
+	stopped  bool
+	err      error // first write error since the last Sync
+}
+
+func newAsyncWriter(out entryWriter, config *AsyncConfig) *asyncWriter {
+	w := &asyncWriter{out: out, size: config.QueueSize, policy: config.Policy}
+	if w.size == 0 {
+		w.size = defaultAsyncQueueSize
+	}
+	if w.policy == "" {
+		w.policy = AsyncBlock
+	}
+	w.notEmpty = sync.NewCond(&w.mu)
+	w.notFull = sync.NewCond(&w.mu)
+	w.written = sync.NewCond(&w.mu)
+	w.queue = make([]outputEntry, 0, w.size)
+	w.spare = make([]outputEntry, 0, w.size)
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) writeEntry(e outputEntry) error {
+	w.mu.Lock()
+	for !w.stopped && len(w.queue) >= w.size {
+		if w.policy == AsyncBlock {
+			w.notFull.Wait()
+			continue
+		}
+		if !w.dropQueued(e.level) {
+			w.mu.Unlock()
+			asyncDropped[e.level-DebugLevel].Add(1)
+			e.buf.Free()
+			return nil
+		}
+	}
+	if w.stopped {
+		// out may be closed once the writer is stopped
+		w.mu.Unlock()
+		e.buf.Free()
+		return errOutputStopped
+	}
+	w.queue = append(w.queue, e)
+	w.queued++
+	w.notEmpty.Signal()
+	w.mu.Unlock()
+	return nil
+}
+
+// dropQueued makes room for an entry with level lvl according to the
+// "droplowest" policy, reporting whether it did.
+func (w *asyncWriter) dropQueued(lvl Level) bool {
+	if w.policy != AsyncDropLowest {
+		return false
+	}
+	lowest := 0
+	for i, e := range w.queue {
+		if e.level < w.queue[lowest].level {
+			lowest = i
+		}
+	}
+	e := w.queue[lowest]
+	if e.level > lvl {
+		return false
+	}
+	asyncDropped[e.level-DebugLevel].Add(1)
+	e.buf.Free()
+	w.queue = append(w.queue[:lowest], w.queue[lowest+1:]...)
+	w.done++
+	return true
+}
+
+// run writes the queued entries until the writer is stopped and drained.
+func (w *asyncWriter) run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for len(w.queue) == 0 && !w.stopped {
+			w.notEmpty.Wait()
+		}
+		if len(w.queue) == 0 {
+			return
+		}
+		batch := w.queue
+		w.queue, w.spare = w.spare[:0], nil
+		w.notFull.Broadcast()
+		w.mu.Unlock()
+
+		var err error
+		for _, e := range batch {
+			if werr := w.out.writeEntry(e); werr != nil && err == nil {
+				err = werr
+			}
+		}
+
+		w.mu.Lock()
+		w.spare = batch[:0]
+		w.done += uint64(len(batch))
+		if w.err == nil {
+			w.err = err
+		}
+		w.written.Broadcast()
+	}
+}
+
+// Sync waits until the entries queued before the call are written, then
+// syncs out. It returns the first write error since the previous Sync.
+func (w *asyncWriter) Sync() error {
+	w.mu.Lock()
+	target := w.queued
+	for w.done < target {
+		w.written.Wait()
+	}
+	err := w.err
+	w.err = nil
+	w.mu.Unlock()
+	return errors.Join(err, w.out.Sync())
+}
+
+// stop drains the queue and ends the background goroutine. Entries written
+// afterwards are rejected with errOutputStopped.
+func (w *asyncWriter) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.notEmpty.Broadcast()
+	w.notFull.Broadcast()
+	for w.done < w.queued {
+		w.written.Wait()
+	}
+	w.mu.Unlock()
+}
@@ -0,0 +1,183 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/buffer"
+)
+
+// gatedWriter blocks writes until the gate is closed.
+type gatedWriter struct {
+	gate    chan struct{}
+	entered chan struct{}
+	once    sync.Once
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{gate: make(chan struct{}), entered: make(chan struct{})}
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.entered) })
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gatedWriter) Sync() error { return nil }
+
+func (w *gatedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+var asyncTestPool = buffer.NewPool()
+
+func asyncEntry(lvl Level, s string) outputEntry {
+	buf := asyncTestPool.Get()
+	buf.AppendString(s + "\n")
+	return outputEntry{level: lvl, buf: buf}
+}
+
+// fillAsyncWriter returns a writer whose flusher is stuck writing "first",
+// with the given lines queued.
+func fillAsyncWriter(t *testing.T, policy string, lines map[string]Level, order ...string) (*asyncWriter, *gatedWriter) {
+	t.Helper()
+	out := newGatedWriter()
+	w := newAsyncWriter(syncWriter{out}, &AsyncConfig{QueueSize: len(order), Policy: policy})
+	_ = w.writeEntry(asyncEntry(InfoLevel, "first"))
+	<-out.entered
+	for _, line := range order {
+		_ = w.writeEntry(asyncEntry(lines[line], line))
+	}
+	return w, out
+}
+
+func TestAsyncDropNewest(t *testing.T) {
+	before := AsyncDropped()[ErrorLevel]
+	w, out := fillAsyncWriter(t, AsyncDropNewest, map[string]Level{"a": InfoLevel, "b": InfoLevel}, "a", "b")
+	_ = w.writeEntry(asyncEntry(ErrorLevel, "dropped"))
+	close(out.gate)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "first\na\nb\n" {
+		t.Fatalf("written %q", got)
+	}
+	if got := AsyncDropped()[ErrorLevel] - before; got != 1 {
+		t.Fatalf("dropped %d error entries, want 1", got)
+	}
+}
+
+func TestAsyncDropLowest(t *testing.T) {
+	before := AsyncDropped()
+	levels := map[string]Level{"info": InfoLevel, "debug": DebugLevel, "warn": WarnLevel}
+	w, out := fillAsyncWriter(t, AsyncDropLowest, levels, "info", "debug", "warn")
+	_ = w.writeEntry(asyncEntry(ErrorLevel, "error"))   // replaces debug
+	_ = w.writeEntry(asyncEntry(InfoLevel, "info 2"))   // replaces info
+	_ = w.writeEntry(asyncEntry(DebugLevel, "debug 2")) // dropped
+	close(out.gate)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "first\nwarn\nerror\ninfo 2\n" {
+		t.Fatalf("written %q", got)
+	}
+	after := AsyncDropped()
+	if after[DebugLevel]-before[DebugLevel] != 2 || after[InfoLevel]-before[InfoLevel] != 1 {
+		t.Fatalf("dropped %v, before %v", after, before)
+	}
+}
+
+func TestAsyncBlock(t *testing.T) {
+	w, out := fillAsyncWriter(t, AsyncBlock, map[string]Level{"a": InfoLevel}, "a")
+	written := make(chan struct{})
+	go func() {
+		_ = w.writeEntry(asyncEntry(InfoLevel, "b"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write didn't block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(out.gate)
+	<-written
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "first\na\nb\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	w.stop()
+	if err := w.writeEntry(asyncEntry(InfoLevel, "after stop")); !errors.Is(err, errOutputStopped) {
+		t.Fatalf("write after stop = %v", err)
+	}
+	if got := out.String(); strings.Contains(got, "after stop") {
+		t.Fatalf("entry after stop written: %q", got)
+	}
+}
+
+func TestAsyncConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	config, err := ParseConfig([]byte("outputPaths: ["+path+"]\nasync: {queueSize: 8, policy: block}\nsampling: null\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		l.Infow("async", "i", i)
+	}
+	if err := l.(interface{ Sync() error }).Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(readLog(t, path), "\n"); n != 100 {
+		t.Fatalf("%d lines written before Sync returned, want 100", n)
+	}
+
+	config.Async.Policy = "whatever"
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestStoppableWriter(t *testing.T) {
+	out := newGatedWriter()
+	w := &stoppableWriter{out: syncWriter{out}}
+	go func() { _ = w.writeEntry(asyncEntry(InfoLevel, "in progress")) }()
+	<-out.entered
+
+	stopped := make(chan struct{})
+	go func() {
+		w.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop didn't wait for the write in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(out.gate)
+	<-stopped
+	if err := w.writeEntry(asyncEntry(InfoLevel, "after stop")); !errors.Is(err, errOutputStopped) {
+		t.Fatalf("write after stop = %v", err)
+	}
+	if got := out.String(); got != "in progress\n" {
+		t.Fatalf("written %q", got)
+	}
+}
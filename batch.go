@@ -0,0 +1,237 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxPendingBatches is the number of batches a batcher holds while sending
+// is slower than logging, before dropping entries.
+const maxPendingBatches = 16
+
+var errBatcherClosed = errors.New("output closed")
+
+// batcherCloseTimeout bounds the time Close spends sending the pending
+// records, the ones left are dropped. Tests shorten it.
+var batcherCloseTimeout = 5 * time.Second
+
+var errCloseTimeout = errors.New("output closed before the entries were sent")
+
+// partialError is returned by the send function of a batcher when only some
+// of the records of a batch were dropped.
+type partialError struct {
+	dropped []*record
+	err     error
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d entries dropped: %v", len(e.dropped), e.err)
+}
+
+func (e *partialError) Unwrap() error {
+	return e.err
+}
+
+// batcher groups records into batches of about size bytes of encoded
+// entries, and hands them to send from a background goroutine when a batch is
+// full, and every wait otherwise. The records it drops are written to the
+// fallbacks of the output.
+//
+// The context passed to send is canceled when the batcher is closed, which
+// ends the request and the retries of the current batch. The batch is then
+// sent again with the records pending at that time, with a new context that
+// has a deadline.
+type batcher struct {
+	size   int
+	wait   time.Duration
+	output string
+	send   func(ctx context.Context, batch []*record) error
+
+	mu       sync.Mutex
+	pending  []*record
+	bytes    int
+	closed   bool
+	fallback *fallbackChain
+
+	full   chan struct{}
+	syncs  chan chan error
+	ctx    context.Context // canceled by Close
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newBatcher(size int, wait time.Duration, output string, send func(context.Context, []*record) error) *batcher {
+	b := &batcher{
+		size:   size,
+		wait:   wait,
+		output: output,
+		send:   send,
+		full:   make(chan struct{}, 1),
+		syncs:  make(chan chan error),
+		done:   make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	go b.run()
+	return b
+}
+
+// add queues r, whose Line must not be reused by the caller.
+func (b *batcher) add(r *record) {
+	b.mu.Lock()
+	if b.closed || b.bytes >= maxPendingBatches*b.size {
+		b.mu.Unlock()
+		err := errBatcherClosed
+		if !b.closed {
+			err = errors.New("too many entries pending")
+		}
+		b.drop([]*record{r}, err)
+		return
+	}
+	b.pending = append(b.pending, r)
+	b.bytes += len(r.Line)
+	full := b.bytes >= b.size
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+	timer := time.NewTimer(b.wait)
+	for {
+		select {
+		case <-timer.C:
+			b.flush(b.ctx)
+		case <-b.full:
+			b.flush(b.ctx)
+		case reply := <-b.syncs:
+			reply <- b.flush(b.ctx)
+		case <-b.ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), batcherCloseTimeout)
+			b.flush(ctx)
+			cancel()
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(b.wait)
+	}
+}
+
+// flush sends the pending records, and returns the last error. When ctx is
+// canceled by Close, the batch being sent and the ones left are pending again
+// for the last flush; the records still pending at the deadline of its
+// context are dropped.
+func (b *batcher) flush(ctx context.Context) error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending, b.bytes = nil, 0
+	b.mu.Unlock()
+
+	var err error
+	for len(pending) > 0 {
+		switch ctxErr := ctx.Err(); {
+		case errors.Is(ctxErr, context.DeadlineExceeded):
+			b.drop(pending, errCloseTimeout)
+			return errCloseTimeout
+		case ctxErr != nil:
+			// closed meanwhile: the last flush sends the rest, within its
+			// deadline
+			b.mu.Lock()
+			b.pending = append(pending, b.pending...)
+			b.mu.Unlock()
+			return err
+		}
+		n, size := 0, 0
+		for n < len(pending) && (n == 0 || size+len(pending[n].Line) <= b.size) {
+			size += len(pending[n].Line)
+			n++
+		}
+		if sendErr := b.send(ctx, pending[:n]); sendErr != nil {
+			err = sendErr
+			if errors.Is(ctx.Err(), context.Canceled) {
+				// closed while sending: the last flush sends the batch again
+				unsent := pending
+				if partial, ok := sendErr.(*partialError); ok {
+					unsent = append(partial.dropped, pending[n:]...)
+				}
+				b.mu.Lock()
+				b.pending = append(unsent, b.pending...)
+				b.mu.Unlock()
+				return err
+			}
+			if partial, ok := sendErr.(*partialError); ok {
+				b.drop(partial.dropped, partial.err)
+			} else {
+				b.drop(pending[:n], err)
+			}
+		}
+		pending = pending[n:]
+	}
+	return err
+}
+
+func (b *batcher) setFallback(f *fallbackChain) {
+	b.mu.Lock()
+	b.fallback = f
+	b.mu.Unlock()
+}
+
+// drop writes the records lost with err to the fallbacks, and reports the
+// ones they didn't take on the Diagnostics channel.
+func (b *batcher) drop(records []*record, err error) {
+	b.mu.Lock()
+	fallback := b.fallback
+	b.mu.Unlock()
+	fallbacks := 0
+	if fallback != nil {
+		for _, r := range records {
+			if r.entry != nil && fallback.write(r.entry) {
+				fallbacks++
+			}
+		}
+	}
+	lost := len(records) - fallbacks
+	countOutputFailure(b.output, err, fallbacks, lost)
+	if lost > 0 {
+		sendDiagnostic(&OutputError{Output: b.output, Dropped: lost, Err: err})
+	}
+}
+
+// Sync sends the pending records, and returns the last error while sending
+// them.
+func (b *batcher) Sync() error {
+	reply := make(chan error, 1)
+	select {
+	case b.syncs <- reply:
+		return <-reply
+	case <-b.done:
+		return nil
+	}
+}
+
+// Close sends the pending records, for batcherCloseTimeout at most, and stops
+// the batcher.
+func (b *batcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+	b.cancel()
+	<-b.done
+	return nil
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes. In config files and URLs it's written as a
+// number with an optional unit, such as "512", "64KB" or "100MB". Units are
+// powers of 1024, "MB" and "MiB" are the same.
+type ByteSize int64
+
+const (
+	KB ByteSize = 1 << (10 * (iota + 1))
+	MB
+	GB
+	TB
+)
+
+var byteSizeUnits = []struct {
+	size  ByteSize
+	names []string
+}{
+	{TB, []string{"TB", "TIB", "T"}},
+	{GB, []string{"GB", "GIB", "G"}},
+	{MB, []string{"MB", "MIB", "M"}},
+	{KB, []string{"KB", "KIB", "K"}},
+	{1, []string{"B", ""}},
+}
+
+// ParseByteSize parses a size such as "100MB".
+func ParseByteSize(s string) (ByteSize, error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(text, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(text)
+	}
+	number, unit := text[:i], strings.TrimSpace(text[i:])
+	for _, u := range byteSizeUnits {
+		for _, name := range u.names {
+			if unit != name {
+				continue
+			}
+			f, err := strconv.ParseFloat(number, 64)
+			if err != nil || f < 0 {
+				return 0, fmt.Errorf("not a valid size: %q", s)
+			}
+			return ByteSize(f * float64(u.size)), nil
+		}
+	}
+	return 0, fmt.Errorf("not a valid size: %q", s)
+}
+
+// String returns the size with the largest unit it's a whole multiple of.
+func (b ByteSize) String() string {
+	for _, u := range byteSizeUnits {
+		if b != 0 && b%u.size == 0 {
+			return strconv.FormatInt(int64(b/u.size), 10) + u.names[0]
+		}
+	}
+	return "0"
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
@@ -1,7 +1,12 @@
 package logger
 
 import (
-	"time"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -10,6 +15,11 @@ import (
 type FieldPair []string
 
 type Config struct {
+	// Preset is the name of the preset the config was built from, see
+	// RegisterPreset. In config files it selects the preset the other
+	// settings are layered over.
+	Preset string `json:"preset,omitempty" yaml:"preset,omitempty"`
+
 	// Level is the minimum enabled logging level. Note that this is a dynamic
 	// level, so calling Config.Level.SetLevel will atomically change the log
 	// level of all loggers descended from this config.
@@ -35,14 +45,56 @@ type Config struct {
 	// See Open for details.
 	OutputPaths []string `json:"outputPaths" yaml:"outputPaths"`
 
+	// FallbackPaths are URLs or file paths the entries are written to, by the
+	// first one that accepts them, when writing them to one of OutputPaths
+	// fails, or when an output sending them in the background drops them.
+	// The failures are counted, see OutputStatuses.
+	FallbackPaths []string `json:"fallbackPaths,omitempty" yaml:"fallbackPaths,omitempty"`
+
+	// ErrorOutputPaths is a list of URLs or file paths the errors of the
+	// logger itself are written to, such as the entries no output nor
+	// fallback could write. They're discarded when it's empty.
+	ErrorOutputPaths []string `json:"errorOutputPaths" yaml:"errorOutputPaths"`
+
+	// Outputs are outputs with settings of their own, such as their minimum
+	// level, written in addition to OutputPaths. See OutputConfig.
+	Outputs []OutputConfig `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+
+	// Rotation, if set, rotates the files of OutputPaths, see RotationConfig.
+	// Other URLs and stdout/stderr are left alone.
+	Rotation *RotationConfig `json:"rotation" yaml:"rotation"`
+
+	// Async, if set, writes to the outputs from a background goroutine, see
+	// AsyncConfig.
+	Async *AsyncConfig `json:"async" yaml:"async"`
+
+	// Sampling sets a sampling policy. A nil SamplingConfig disables sampling.
+	Sampling *SamplingConfig `json:"sampling" yaml:"sampling"`
+
+	// Encoder customizes the keys and formats of encoded entries.
+	Encoder EncoderConfig `json:"encoder" yaml:"encoder"`
+
 	// InitialFields is a collection of fields to add to the root logger.
 	InitialFields map[string]interface{} `json:"initialFields" yaml:"initialFields"`
 
-	EnableColor bool
-	ShortTime   bool
+	// EnableColor colorizes level names, only meaningful with the console encoding.
+	EnableColor bool `json:"enableColor" yaml:"enableColor"`
+
+	// ShortTime prints timestamps as "2006-01-02 15:04:05" instead of ISO8601.
+	ShortTime bool `json:"shortTime" yaml:"shortTime"`
 
-	CallerSkip int
-	zapConfig  *zap.Config
+	// CallerSkip is the number of stack frames to skip when annotating logs
+	// with the caller.
+	CallerSkip int `json:"callerSkip" yaml:"callerSkip"`
+
+	// invalidFields are the FieldPairs passed to a constructor that aren't a
+	// key and a value, reported by Validate.
+	invalidFields []FieldPair
+	zapConfig     *zap.Config
+	// errorOutput is ErrorOutputPaths opened, set by buildCore.
+	errorOutput zapcore.WriteSyncer
+	// outputLevels are the runtime levels of Outputs, set with zapConfig.
+	outputLevels []zap.AtomicLevel
 }
 
 type ConfigInterface interface {
@@ -53,6 +105,70 @@ type ConfigInterface interface {
 	GetInitialFields() map[string]interface{}
 }
 
+// The interfaces below are optional extensions of ConfigInterface.
+// NewConfigFromInterface applies the settings of every one of them the
+// ConfigInterface also implements, so frameworks can pass everything Config
+// supports while existing implementations keep compiling.
+
+// OutputPathsConfigInterface provides several outputs. When implemented with
+// a non-empty list it takes precedence over GetOutput.
+type OutputPathsConfigInterface interface {
+	GetOutputPaths() []string
+}
+
+// FallbackPathsConfigInterface provides the fallbacks of the output paths.
+type FallbackPathsConfigInterface interface {
+	GetFallbackPaths() []string
+}
+
+// ErrorOutputPathsConfigInterface provides the outputs of the errors of the
+// logger itself.
+type ErrorOutputPathsConfigInterface interface {
+	GetErrorOutputPaths() []string
+}
+
+type DevelopmentConfigInterface interface {
+	GetDevelopment() bool
+}
+
+type ColorConfigInterface interface {
+	GetEnableColor() bool
+}
+
+type ShortTimeConfigInterface interface {
+	GetShortTime() bool
+}
+
+type CallerConfigInterface interface {
+	GetDisableCaller() bool
+	GetCallerSkip() int
+}
+
+// SamplingConfigInterface provides the sampling policy, nil disables sampling.
+type SamplingConfigInterface interface {
+	GetSampling() *SamplingConfig
+}
+
+type EncoderConfigInterface interface {
+	GetEncoder() EncoderConfig
+}
+
+// RotationConfigInterface provides the rotation of file outputs, nil
+// disables rotation.
+type RotationConfigInterface interface {
+	GetRotation() *RotationConfig
+}
+
+// OutputsConfigInterface provides outputs with settings of their own.
+type OutputsConfigInterface interface {
+	GetOutputs() []OutputConfig
+}
+
+// AsyncConfigInterface provides the async mode, nil writes synchronously.
+type AsyncConfigInterface interface {
+	GetAsync() *AsyncConfig
+}
+
 func (c *Config) GetLevel() string {
 	return c.Level.String()
 }
@@ -76,19 +192,76 @@ func (c *Config) GetInitialFields() map[string]interface{} {
 	return c.InitialFields
 }
 
+func (c *Config) GetOutputPaths() []string {
+	return c.OutputPaths
+}
+
+func (c *Config) GetFallbackPaths() []string {
+	return c.FallbackPaths
+}
+
+func (c *Config) GetErrorOutputPaths() []string {
+	return c.ErrorOutputPaths
+}
+
+func (c *Config) GetDevelopment() bool {
+	return c.Development
+}
+
+func (c *Config) GetEnableColor() bool {
+	return c.EnableColor
+}
+
+func (c *Config) GetShortTime() bool {
+	return c.ShortTime
+}
+
+func (c *Config) GetDisableCaller() bool {
+	return c.DisableCaller
+}
+
+func (c *Config) GetCallerSkip() int {
+	return c.CallerSkip
+}
+
+func (c *Config) GetSampling() *SamplingConfig {
+	return c.Sampling
+}
+
+func (c *Config) GetEncoder() EncoderConfig {
+	return c.Encoder
+}
+
+func (c *Config) GetRotation() *RotationConfig {
+	return c.Rotation
+}
+
+func (c *Config) GetOutputs() []OutputConfig {
+	return c.Outputs
+}
+
+func (c *Config) GetAsync() *AsyncConfig {
+	return c.Async
+}
+
 func NewProductionConfig(fields ...FieldPair) *Config {
+	initialFields, invalidFields := genInitialFields(fields)
 	return &Config{
 		Level:             InfoLevel,
 		Development:       false,
 		Encoding:          "json",
 		OutputPaths:       []string{"stderr"},
+		ErrorOutputPaths:  []string{"stderr"},
+		Sampling:          newDefaultSamplingConfig(),
 		CallerSkip:        2,
 		DisableStacktrace: false,
-		InitialFields:     genInitialFields(fields),
+		InitialFields:     initialFields,
+		invalidFields:     invalidFields,
 	}
 }
 
 func NewDevelopmentConfig(fields ...FieldPair) *Config {
+	initialFields, invalidFields := genInitialFields(fields)
 	return &Config{
 		Level:             DebugLevel,
 		Development:       true,
@@ -96,12 +269,19 @@ func NewDevelopmentConfig(fields ...FieldPair) *Config {
 		EnableColor:       true,
 		Encoding:          "console",
 		OutputPaths:       []string{"stderr"},
+		ErrorOutputPaths:  []string{"stderr"},
+		Sampling:          newDefaultSamplingConfig(),
 		CallerSkip:        2,
 		DisableStacktrace: true,
-		InitialFields:     genInitialFields(fields),
+		InitialFields:     initialFields,
+		invalidFields:     invalidFields,
 	}
 }
 
+func newDefaultSamplingConfig() *SamplingConfig {
+	return &SamplingConfig{Tick: defaultSamplingTick, Initial: 100, Thereafter: 100}
+}
+
 func NewDefaultConfig(fields ...FieldPair) *Config {
 	return NewProductionConfig(fields...)
 }
@@ -121,78 +301,335 @@ func NewConfigFromInterface(c ConfigInterface) *Config {
 	}
 	dft.DisableStacktrace = c.GetDisableStacktrace()
 	dft.InitialFields = c.GetInitialFields()
+
+	if o, ok := c.(OutputPathsConfigInterface); ok && len(o.GetOutputPaths()) > 0 {
+		dft.OutputPaths = append([]string(nil), o.GetOutputPaths()...)
+	}
+	if f, ok := c.(FallbackPathsConfigInterface); ok {
+		dft.FallbackPaths = append([]string(nil), f.GetFallbackPaths()...)
+	}
+	if e, ok := c.(ErrorOutputPathsConfigInterface); ok {
+		dft.ErrorOutputPaths = append([]string(nil), e.GetErrorOutputPaths()...)
+	}
+	if d, ok := c.(DevelopmentConfigInterface); ok {
+		dft.Development = d.GetDevelopment()
+	}
+	if cc, ok := c.(ColorConfigInterface); ok {
+		dft.EnableColor = cc.GetEnableColor()
+	}
+	if st, ok := c.(ShortTimeConfigInterface); ok {
+		dft.ShortTime = st.GetShortTime()
+	}
+	if cc, ok := c.(CallerConfigInterface); ok {
+		dft.DisableCaller = cc.GetDisableCaller()
+		dft.CallerSkip = cc.GetCallerSkip()
+	}
+	if s, ok := c.(SamplingConfigInterface); ok {
+		dft.Sampling = s.GetSampling().clone()
+	}
+	if e, ok := c.(EncoderConfigInterface); ok {
+		dft.Encoder = e.GetEncoder()
+	}
+	if r, ok := c.(RotationConfigInterface); ok {
+		dft.Rotation = r.GetRotation().clone()
+	}
+	if o, ok := c.(OutputsConfigInterface); ok {
+		dft.Outputs = cloneOutputs(o.GetOutputs())
+	}
+	if a, ok := c.(AsyncConfigInterface); ok {
+		dft.Async = a.GetAsync().clone()
+	}
 	return dft
 }
 
-func genInitialFields(args []FieldPair) map[string]interface{} {
+// genInitialFields converts args to initial fields, returning the pairs that
+// aren't a key and a value separately. As before Validate, pairs with extra
+// elements are kept with their first two; shorter ones have no value and are
+// left out.
+func genInitialFields(args []FieldPair) (map[string]interface{}, []FieldPair) {
 	fields := make(map[string]interface{})
+	var invalid []FieldPair
 	for _, f := range args {
-		fields[f[0]] = f[1]
+		if len(f) != 2 {
+			invalid = append(invalid, f)
+		}
+		if len(f) >= 2 {
+			fields[f[0]] = f[1]
+		}
+	}
+	return fields, invalid
+}
+
+// Validate checks the encoding, level, output paths, encoder settings,
+// sampling and initial fields of c up front, returning all problems found at
+// once.
+func (c *Config) Validate() error {
+	var errs []error
+	if err := checkEncoding(c.Encoding); err != nil {
+		errs = append(errs, err)
 	}
-	return fields
+	if c.Level < DebugLevel || c.Level > FatalLevel {
+		errs = append(errs, fmt.Errorf("not a valid logger Level: %d", c.Level))
+	}
+	if len(c.OutputPaths) == 0 && len(c.Outputs) == 0 {
+		errs = append(errs, errors.New("no output paths"))
+	}
+	// rotating files create their directory, so they aren't checked
+	for _, path := range c.outputURLs() {
+		if err := checkOutputPath(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, path := range append(append([]string(nil), c.FallbackPaths...), c.ErrorOutputPaths...) {
+		if err := checkOutputPath(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, o := range c.Outputs {
+		errs = append(errs, c.validateOutput(o)...)
+	}
+	for _, f := range c.invalidFields {
+		errs = append(errs, fmt.Errorf("not a valid field pair: %q, want [key value]", []string(f)))
+	}
+	errs = append(errs, c.Encoder.validate()...)
+	errs = append(errs, c.Sampling.validate()...)
+	errs = append(errs, c.Rotation.validate()...)
+	errs = append(errs, c.Async.validate()...)
+	if _, ok := c.InitialFields[""]; ok {
+		errs = append(errs, errors.New("initial field with empty key"))
+	}
+	return errors.Join(errs...)
+}
+
+// checkOutputPath reports empty paths and files whose directory doesn't
+// exist. Other URL schemes are checked by their sink when it's opened.
+func checkOutputPath(path string) error {
+	if path == "" {
+		return errors.New("empty output path")
+	}
+	file, ok := outputFile(path)
+	if !ok {
+		return nil
+	}
+	dir := filepath.Dir(file)
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("output path %q: %w", path, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("output path %q: %s is not a directory", path, dir)
+	}
+	return nil
 }
 
-func (c *Config) buildZapConfig() {
-	encoderConfig := c.newCustomEncoderConfig()
+func (c *Config) buildZapConfig() error {
+	if err := c.Encoder.resolveTimeZone(); err != nil {
+		return err
+	}
+	encoderConfig, err := c.Encoder.zapEncoderConfig(c.ShortTime, c.EnableColor)
+	if err != nil {
+		return err
+	}
 
 	zapConfig := &zap.Config{
 		Level:             zap.NewAtomicLevelAt(zapcore.Level(c.Level)),
 		Development:       c.Development,
 		DisableCaller:     c.DisableCaller,
 		DisableStacktrace: c.DisableStacktrace,
-		Sampling:          &zap.SamplingConfig{Initial: 100, Thereafter: 100},
+		Sampling:          nil, // see SamplingConfig.wrapCore
 		Encoding:          c.Encoding,
 		EncoderConfig:     encoderConfig,
-		OutputPaths:       c.OutputPaths,
+		OutputPaths:       c.outputURLs(),
+		ErrorOutputPaths:  c.ErrorOutputPaths,
 		InitialFields:     c.InitialFields,
 	}
 	c.zapConfig = zapConfig
+	c.outputLevels = newOutputLevels(c.Outputs)
+	return nil
 }
 
-func (c *Config) newCustomEncoderConfig() zapcore.EncoderConfig {
-	encodeLevel := zapcore.LowercaseLevelEncoder
-	if c.EnableColor {
-		encodeLevel = zapcore.LowercaseColorLevelEncoder
+// buildCore opens the outputs of c and returns the core writing to them, with
+// the function stopping its background work. buildZapConfig must have been
+// called.
+func (c *Config) buildCore() (zapcore.Core, func(), error) {
+	var cores []zapcore.Core
+	var stops []func()
+	closeErrorOutput := func() {}
+	stop := func() {
+		for _, s := range stops {
+			s()
+		}
+		// last, as the outputs may report errors until they're closed
+		closeErrorOutput()
+	}
+	addCore := func(out entryWriter, records bool, enc zapcore.Encoder, enab zapcore.LevelEnabler) {
+		// loggers that resolved the core before a reload may still write to
+		// it, which must be over before the outputs are closed
+		gate := &stoppableWriter{}
+		stops = append(stops, gate.stop)
+		if c.Async != nil {
+			w := newAsyncWriter(out, c.Async)
+			stops = append(stops, w.stop)
+			out = w
+		}
+		gate.out = out
+		cores = append(cores, newOutputCore(enc, gate, records, enab))
+	}
+	// openFallbacks returns the fallback chain of the output at path, and
+	// the function closing its outputs
+	openFallbacks := func(path string, fallbacks []string) (*fallbackChain, func(), error) {
+		f := &fallbackChain{output: statusName(path)}
+		registerOutput(f.output)
+		var closes []func()
+		closeAll := func() {
+			for _, c := range closes {
+				c()
+			}
+		}
+		for _, fallback := range fallbacks {
+			out, closeOut, err := zap.Open(fallback)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			f.outs = append(f.outs, out)
+			closes = append(closes, closeOut)
+		}
+		return f, closeAll, nil
 	}
-	encodeTime := zapcore.ISO8601TimeEncoder
-	if c.ShortTime {
-		encodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-			type appendTimeEncoder interface {
-				AppendTimeLayout(time.Time, string)
+	// addOutputs adds a core for the record sinks of paths, and one for the
+	// other outputs together
+	addOutputs := func(paths, fallbacks []string, e outputEncoding, enab zapcore.LevelEnabler) error {
+		enc := newEncoder(e.encoding, e.config)
+		var others []zapcore.WriteSyncer
+		// the outputs opened with zap.Open and the fallbacks are closed after
+		// the cores writing to them are stopped
+		var closes []func()
+		defer func() { stops = append(stops, closes...) }()
+		for _, path := range paths {
+			fallback, closeFallbacks, err := openFallbacks(path, fallbacks)
+			if err != nil {
+				return err
 			}
-			layout := "2006-01-02 15:04:05"
-			if enc, ok := enc.(appendTimeEncoder); ok {
-				enc.AppendTimeLayout(t, layout)
-				return
+			closes = append(closes, closeFallbacks)
+			u := c.outputURL(path)
+			sink, err := openRecordSink(u)
+			if err != nil {
+				return err
 			}
-			enc.AppendString(t.Format(layout))
+			if sink == nil {
+				out, closeOut, err := zap.Open(u)
+				if err != nil {
+					return err
+				}
+				closes = append(closes, closeOut)
+				others = append(others, fallbackSyncer{out, fallback})
+				continue
+			}
+			if s, ok := sink.(encodingSink); ok {
+				s.setEncoder(e.encoding, e.plain)
+			}
+			if s, ok := sink.(fallbackSink); ok {
+				s.setFallback(fallback)
+			}
+			addCore(recordWriter{sink, fallback}, true, enc.Clone(), enab)
+			// after the asynchronous writer, which may still write to it
+			stops = append(stops, func() { _ = sink.Close() })
+		}
+		if len(others) == 0 {
+			return nil
+		}
+		addCore(syncWriter{zap.CombineWriteSyncers(others...)}, false, enc, enab)
+		return nil
+	}
+
+	if len(c.ErrorOutputPaths) > 0 {
+		out, closeOut, err := zap.Open(c.ErrorOutputPaths...)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.errorOutput, closeErrorOutput = out, closeOut
+	}
+	if len(c.OutputPaths) > 0 {
+		plain, _ := c.Encoder.zapEncoderConfig(c.ShortTime, false)
+		e := outputEncoding{encoding: c.Encoding, config: c.zapConfig.EncoderConfig, plain: plain}
+		if err := addOutputs(c.OutputPaths, c.FallbackPaths, e, c.zapConfig.Level); err != nil {
+			stop()
+			return nil, nil, err
+		}
+	}
+	for i, o := range c.Outputs {
+		e, err := c.outputEncoding(o)
+		if err == nil {
+			enab := outputEnabler{logger: c.zapConfig.Level, output: c.outputLevels[i]}
+			err = addOutputs([]string{o.Path}, o.Fallback, e, enab)
 		}
+		if err != nil {
+			stop()
+			return nil, nil, err
+		}
+	}
+	return zapcore.NewTee(cores...), stop, nil
+}
+
+// buildOptions returns the options zap.Config.Build would use for c, apart
+// from sampling which is applied by SamplingConfig.wrapCore.
+func (c *Config) buildOptions() []zap.Option {
+	errorOutput := c.errorOutput
+	if errorOutput == nil {
+		errorOutput = zapcore.AddSync(io.Discard)
 	}
-	return zapcore.EncoderConfig{
-		TimeKey:        "ts",
-		LevelKey:       "level",
-		NameKey:        "logger",
-		CallerKey:      "caller",
-		MessageKey:     "msg",
-		StacktraceKey:  "stacktrace",
-		LineEnding:     zapcore.DefaultLineEnding,
-		EncodeLevel:    encodeLevel,
-		EncodeTime:     encodeTime,
-		EncodeDuration: zapcore.SecondsDurationEncoder,
-		EncodeCaller:   zapcore.ShortCallerEncoder,
+	opts := []zap.Option{zap.ErrorOutput(errorOutput)}
+	if c.Development {
+		opts = append(opts, zap.Development())
 	}
+	if !c.DisableCaller {
+		opts = append(opts, zap.AddCaller())
+	}
+	stackLevel := zap.ErrorLevel
+	if c.Development {
+		stackLevel = zap.WarnLevel
+	}
+	if !c.DisableStacktrace {
+		opts = append(opts, zap.AddStacktrace(stackLevel))
+	}
+	if len(c.InitialFields) > 0 {
+		keys := make([]string, 0, len(c.InitialFields))
+		for k := range c.InitialFields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]zap.Field, 0, len(keys))
+		for _, k := range keys {
+			fields = append(fields, zap.Any(k, c.InitialFields[k]))
+		}
+		opts = append(opts, zap.Fields(fields...))
+	}
+	return opts
 }
 
 func (c *Config) clone() *Config {
 	cloned := *c
 	cloned.OutputPaths = make([]string, len(c.OutputPaths))
 	copy(cloned.OutputPaths, c.OutputPaths)
+	cloned.FallbackPaths = append([]string(nil), c.FallbackPaths...)
+	cloned.ErrorOutputPaths = append([]string(nil), c.ErrorOutputPaths...)
+	cloned.Sampling = c.Sampling.clone()
+	cloned.Rotation = c.Rotation.clone()
+	cloned.Async = c.Async.clone()
+	cloned.Outputs = cloneOutputs(c.Outputs)
 	cloned.InitialFields = make(map[string]interface{})
 	for k, v := range c.InitialFields {
 		cloned.InitialFields[k] = v
 	}
 	if cloned.zapConfig != nil {
-		cloned.buildZapConfig()
+		// the level may have been changed at runtime through the zap config
+		cloned.Level = Level(c.zapConfig.Level.Level())
+		for i, lvl := range c.outputLevels {
+			if l := Level(lvl.Level()); cloned.Outputs[i].Level != nil || l != DebugLevel {
+				cloned.Outputs[i].Level = &l
+			}
+		}
+		// c was built before, so this can't fail
+		_ = cloned.buildZapConfig()
 	}
 	return &cloned
 }
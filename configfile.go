@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfigFile reads a Config from a JSON or YAML file, picking the format
+// from the file extension. See ParseConfig for how the content is decoded.
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	config, err := ParseConfig(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("load config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfig decodes data in the given format ("json", "yaml" or "yml") into
+// a Config. The decoded keys are layered over the preset named by "preset"
+// (see RegisterPreset), or else over NewDevelopmentConfig when "development"
+// is true and over NewProductionConfig otherwise, so a file only needs to
+// list what it changes.
+//
+// Decoding is strict: unknown keys, unknown levels and unsupported encodings
+// are reported as errors instead of being ignored.
+func ParseConfig(data []byte, format string) (*Config, error) {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "json":
+		// JSON is a subset of YAML, so both formats go through the YAML
+		// decoder and share its strict field checking and duration parsing.
+		// Syntax errors are still reported by encoding/json for clarity.
+		if !json.Valid(data) {
+			var v interface{}
+			return nil, fmt.Errorf("parse json config: %w", json.Unmarshal(data, &v))
+		}
+	case "yaml", "yml":
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+
+	config, err := baseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if err := checkEncoding(config.Encoding); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// baseConfig returns the defaults the content of data is layered over.
+func baseConfig(data []byte) (*Config, error) {
+	var base struct {
+		Preset      string `yaml:"preset"`
+		Development bool   `yaml:"development"`
+	}
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if base.Preset != "" {
+		return NewConfigFromPreset(base.Preset)
+	}
+	if base.Development {
+		return NewDevelopmentConfig(), nil
+	}
+	return NewProductionConfig(), nil
+}
+
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case "json", "console":
+		return nil
+	}
+	return fmt.Errorf("not a valid encoding: %q", encoding)
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigYAML(t *testing.T) {
+	data := []byte(`
+level: warn
+development: true
+encoding: json
+outputPaths: ["stdout", "app.log"]
+enableColor: false
+callerSkip: 3
+initialFields:
+  service: api
+`)
+	config, err := ParseConfig(data, "yaml")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if config.Level != WarnLevel {
+		t.Fatalf("Level = %v, want warn", config.Level)
+	}
+	if config.Encoding != "json" || config.EnableColor || config.CallerSkip != 3 {
+		t.Fatalf("decoded config mismatch: %+v", config)
+	}
+	// not set in the file, so it comes from the development defaults
+	if !config.ShortTime || !config.DisableStacktrace {
+		t.Fatalf("development defaults not applied: %+v", config)
+	}
+	if len(config.OutputPaths) != 2 || config.InitialFields["service"] != "api" {
+		t.Fatalf("decoded config mismatch: %+v", config)
+	}
+}
+
+func TestParseConfigJSON(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"level": "error", "shortTime": true}`), ".json")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if config.Level != ErrorLevel || !config.ShortTime || config.Encoding != "json" {
+		t.Fatalf("decoded config mismatch: %+v", config)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	cases := map[string]struct {
+		data   string
+		format string
+		want   string // in the error, if set
+	}{
+		"unknown key":      {"level: info\ncolour: true\n", "yaml", "colour"},
+		"bad level":        {"level: loud\n", "yaml", ""},
+		"numeric level":    {"level: 1\n", "yaml", ""},
+		"bad encoding":     {"encoding: xml\n", "yaml", ""},
+		"bad json":         {`{"level": "info",}`, "json", ""},
+		"unknown json key": {`{"outputz": []}`, "json", "outputz"},
+		"bad format":       {"level = info", "toml", ""},
+	}
+	for name, c := range cases {
+		_, err := ParseConfig([]byte(c.data), c.format)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		} else if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: %v, want %q in it", name, err, c.want)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logger.yml")
+	if err := os.WriteFile(path, []byte("level: debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	if config.Level != DebugLevel || config.Encoding != "json" {
+		t.Fatalf("decoded config mismatch: %+v", config)
+	}
+
+	if _, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// coreRef holds the zap core shared by a root logger and every logger derived
+// from it. Storing a new core reconfigures all of them at once.
+type coreRef struct {
+	current atomic.Pointer[coreGen]
+}
+
+// coreGen is one generation of the core held by a coreRef.
+type coreGen struct {
+	core zapcore.Core
+	stop func() // stops the background work of core, if any
+}
+
+func newCoreRef(core zapcore.Core, stop func()) *coreRef {
+	ref := &coreRef{}
+	ref.store(core, stop)
+	return ref
+}
+
+// store replaces the core of r, then stops the previous one.
+func (r *coreRef) store(core zapcore.Core, stop func()) {
+	if old := r.current.Swap(&coreGen{core: core, stop: stop}); old != nil && old.stop != nil {
+		old.stop()
+	}
+}
+
+// reloadableCore forwards to the core currently held by its ref, with the
+// fields added through With applied on top. The fields are re-applied lazily
+// the first time a new generation of the core is used.
+type reloadableCore struct {
+	ref    *coreRef
+	fields []zapcore.Field
+	cache  atomic.Pointer[resolvedCore]
+}
+
+type resolvedCore struct {
+	gen  *coreGen
+	core zapcore.Core
+}
+
+var _ zapcore.Core = (*reloadableCore)(nil)
+
+func newReloadableCore(ref *coreRef) *reloadableCore {
+	return &reloadableCore{ref: ref}
+}
+
+func (c *reloadableCore) resolve() zapcore.Core {
+	gen := c.ref.current.Load()
+	if r := c.cache.Load(); r != nil && r.gen == gen {
+		return r.core
+	}
+	core := gen.core
+	if len(c.fields) > 0 {
+		core = core.With(c.fields)
+	}
+	c.cache.Store(&resolvedCore{gen: gen, core: core})
+	return core
+}
+
+func (c *reloadableCore) Enabled(lvl zapcore.Level) bool {
+	return c.ref.current.Load().core.Enabled(lvl)
+}
+
+func (c *reloadableCore) With(fields []zapcore.Field) zapcore.Core {
+	merged := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	merged = append(merged, c.fields...)
+	merged = append(merged, fields...)
+	return &reloadableCore{ref: c.ref, fields: merged}
+}
+
+func (c *reloadableCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	// the resolved core adds itself to ce, so Write is only reached when
+	// reloadableCore is wrapped by another core
+	return c.resolve().Check(ent, ce)
+}
+
+func (c *reloadableCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.resolve().Write(ent, fields)
+}
+
+func (c *reloadableCore) Sync() error {
+	return c.resolve().Sync()
+}
@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The elasticsearch outputs index entries into Elasticsearch or OpenSearch
+// with the _bulk API, elasticsearch over HTTP and elasticsearch+https over
+// HTTPS:
+//
+//	elasticsearch://localhost:9200/logs-{date}?dateFormat=2006.01
+//
+// The last element of the path is the name of the index, logs-{date} by
+// default, where {date} is the time of the entry in UTC, formatted with
+// dateFormat, 2006.01.02 by default, and {hostname} the name of the host.
+// The query parameters are the ones of the http outputs, apart from
+// contentType.
+//
+// The documents are the entries encoded as JSON with the encoder settings of
+// the output, so they have the same field names as the JSON encoder, and are
+// created with the create action, which also works with data streams. The
+// items rejected with a 429 or 5xx status are retried as the requests are,
+// the others are dropped and reported on the Diagnostics channel.
+const (
+	ElasticsearchScheme      = "elasticsearch"
+	ElasticsearchHTTPSScheme = "elasticsearch+https"
+)
+
+const defaultElasticsearchIndex = "logs-{date}"
+
+func init() {
+	registerRecordSink(ElasticsearchScheme, newElasticsearchSink)
+	registerRecordSink(ElasticsearchHTTPSScheme, newElasticsearchSink)
+}
+
+type elasticsearchSink struct {
+	*batcher
+	poster     *poster
+	endpoint   string
+	index      string
+	dateFormat string
+	enc        zapcore.Encoder
+}
+
+func newElasticsearchSink(u *url.URL) (recordSink, error) {
+	o, endpoint, err := parseHTTPOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	o.header.Set("Content-Type", "application/x-ndjson")
+	s := &elasticsearchSink{index: defaultElasticsearchIndex, dateFormat: "2006.01.02"}
+	for key, values := range endpoint.Query() {
+		if key != "dateFormat" {
+			return nil, fmt.Errorf("output %s: %s: unknown parameter", outputName(u), key)
+		}
+		s.dateFormat = values[len(values)-1]
+	}
+
+	prefix, index := path.Split(endpoint.Path)
+	if index != "" {
+		s.index = index
+	}
+	endpoint.Scheme = "http"
+	if u.Scheme == ElasticsearchHTTPSScheme {
+		endpoint.Scheme = "https"
+	}
+	endpoint.Path = path.Join("/", prefix, "_bulk")
+	endpoint.RawPath = ""
+	endpoint.RawQuery = ""
+	s.poster, s.endpoint = newPoster(o), endpoint.String()
+	s.batcher = newBatcher(int(o.batchSize), o.batchWait, outputName(u), s.send)
+	return s, nil
+}
+
+func (s *elasticsearchSink) setEncoder(_ string, config zapcore.EncoderConfig) {
+	s.enc = newEncoder("json", config)
+}
+
+// Write indexes p, which should be a JSON object, as an InfoLevel entry.
+func (s *elasticsearchSink) Write(p []byte) (int, error) {
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now()}, Line: p}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *elasticsearchSink) writeRecord(r *record) error {
+	doc := bytes.TrimSpace(r.Line)
+	if s.enc != nil {
+		buf, err := encodeRecord(s.enc, r, nil)
+		if err != nil {
+			return err
+		}
+		defer buf.Free()
+		doc = bytes.TrimSpace(buf.Bytes())
+	} else if !json.Valid(doc) {
+		doc, _ = json.Marshal(map[string]string{"msg": string(doc)})
+	}
+	r.Line = append([]byte(nil), doc...)
+	s.add(r)
+	return nil
+}
+
+// indexName returns the name of the index of r.
+func (s *elasticsearchSink) indexName(r *record) string {
+	return strings.NewReplacer(
+		"{date}", r.Time.UTC().Format(s.dateFormat),
+		"{hostname}", hostname,
+	).Replace(s.index)
+}
+
+// bulkResponse is the part of the response of the _bulk API the sink uses.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func (s *elasticsearchSink) send(ctx context.Context, batch []*record) error {
+	var dropped []*record
+	var lastErr error
+	for attempt := 0; len(batch) > 0; attempt++ {
+		var body bytes.Buffer
+		for _, r := range batch {
+			action, _ := json.Marshal(map[string]interface{}{"create": map[string]string{"_index": s.indexName(r)}})
+			body.Write(action)
+			body.WriteByte('\n')
+			body.Write(r.Line)
+			body.WriteByte('\n')
+		}
+		data, err := s.poster.post(ctx, s.endpoint, body.Bytes(), nil)
+		if err != nil {
+			return &partialError{dropped: append(dropped, batch...), err: err}
+		}
+		var resp bulkResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return &partialError{dropped: append(dropped, batch...), err: fmt.Errorf("bulk response: %w", err)}
+		}
+		if !resp.Errors {
+			break
+		}
+		if len(resp.Items) != len(batch) {
+			return &partialError{dropped: append(dropped, batch...), err: errors.New("bulk response: wrong number of items")}
+		}
+
+		var retry []*record
+		for i, item := range resp.Items {
+			for _, result := range item {
+				switch {
+				case result.Status < 300:
+				case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+					retry = append(retry, batch[i])
+				default:
+					dropped = append(dropped, batch[i])
+					lastErr = fmt.Errorf("%d %s: %s", result.Status, result.Error.Type, result.Error.Reason)
+				}
+			}
+		}
+		if len(retry) > 0 && attempt >= s.poster.retries {
+			dropped = append(dropped, retry...)
+			lastErr = fmt.Errorf("%d entries still rejected after %d retries", len(retry), attempt)
+			break
+		}
+		if len(retry) > 0 && !sleep(ctx, s.poster.delay(attempt)) {
+			dropped = append(dropped, retry...)
+			lastErr = fmt.Errorf("%d entries rejected when the output was closed", len(retry))
+			break
+		}
+		batch = retry
+	}
+	if len(dropped) > 0 {
+		return &partialError{dropped: dropped, err: lastErr}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestElasticsearchBulk(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var actions []string
+	docs := make(map[string]map[string]interface{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		var items []string
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			actions = append(actions, scanner.Text())
+			scanner.Scan()
+			var doc map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				t.Errorf("document %s: %v", scanner.Text(), err)
+			}
+			msg := doc["message"].(string)
+			// "busy" is rejected once, "bad" always
+			status := 201
+			if msg == "bad" || msg == "busy" && docs["busy"] == nil {
+				status = 400
+				if msg == "busy" {
+					status = 429
+					docs["busy"] = map[string]interface{}{}
+				}
+			} else {
+				docs[msg] = doc
+			}
+			items = append(items, fmt.Sprintf(`{"create":{"status":%d,"error":{"type":"mapper_parsing_exception","reason":"%s"}}}`, status, msg))
+		}
+		fmt.Fprintf(w, `{"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer srv.Close()
+
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	config := NewProductionConfig()
+	config.OutputPaths = []string{strings.Replace(srv.URL, "http", "elasticsearch", 1) + "/es/app-{date}?backoff=1ms"}
+	config.Encoding = "console"
+	config.Encoder.MessageKey = "message"
+	config.Sampling = nil
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Infow("ok", "user", "bob")
+	l.Info("busy")
+	l.Info("bad")
+	if err := syncLogger(l); err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Fatalf("Sync() = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 2 || paths[0] != "/es/_bulk" || len(actions) != 4 {
+		t.Fatalf("requests %v with %d actions, want 2 with 4", paths, len(actions))
+	}
+	index := "app-" + time.Now().UTC().Format("2006.01.02")
+	if actions[0] != `{"create":{"_index":"`+index+`"}}` {
+		t.Fatalf("action = %s", actions[0])
+	}
+	if doc := docs["ok"]; doc == nil || doc["user"] != "bob" || doc["level"] != "info" {
+		t.Fatalf("document = %v", doc)
+	}
+	if docs["busy"]["message"] != "busy" {
+		t.Fatal("rejected item not retried")
+	}
+	select {
+	case e := <-Diagnostics():
+		if e.Dropped != 1 {
+			t.Fatalf("diagnostic = %v", e)
+		}
+	default:
+		t.Fatal("failure not reported")
+	}
+}
+
+func TestElasticsearchCloseEndsRetries(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"errors":true,"items":[{"create":{"status":429}}]}`)
+	}))
+	defer srv.Close()
+
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	sink, err := openRecordSink(strings.Replace(srv.URL, "http", "elasticsearch", 1) + "/app?backoff=1h&maxBackoff=1h&batchWait=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = sink.Write([]byte(`{"message":"busy"}` + "\n"))
+	for start := time.Now(); atomic.LoadInt32(&requests) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("no request")
+		}
+	}
+	closeWithin(t, sink, 5*time.Second)
+	if e := <-Diagnostics(); e.Dropped != 1 {
+		t.Fatalf("diagnostic = %v", e)
+	}
+}
+
+func TestElasticsearchURL(t *testing.T) {
+	s, err := openRecordSink("elasticsearch+https://search:9200?dateFormat=2006.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	es := s.(*elasticsearchSink)
+	if es.endpoint != "https://search:9200/_bulk" {
+		t.Fatalf("endpoint = %s", es.endpoint)
+	}
+	r := &record{}
+	r.Time = time.Date(2024, 3, 9, 23, 0, 0, 0, time.FixedZone("", -3600))
+	if index := es.indexName(r); index != "logs-2024.03" {
+		t.Fatalf("index = %s", index)
+	}
+	if _, err := openRecordSink("elasticsearch://search:9200?pipeline=x"); err == nil {
+		t.Fatal("expected error for unknown parameter")
+	}
+}
@@ -0,0 +1,271 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// EncoderConfig customizes the keys and formats of encoded entries. Zero
+// values keep the defaults; a key set to "-" is left out of the entries.
+type EncoderConfig struct {
+	TimeKey       string `json:"timeKey" yaml:"timeKey"`             // default "ts"
+	LevelKey      string `json:"levelKey" yaml:"levelKey"`           // default "level"
+	NameKey       string `json:"nameKey" yaml:"nameKey"`             // default "logger"
+	CallerKey     string `json:"callerKey" yaml:"callerKey"`         // default "caller"
+	MessageKey    string `json:"messageKey" yaml:"messageKey"`       // default "msg"
+	StacktraceKey string `json:"stacktraceKey" yaml:"stacktraceKey"` // default "stacktrace"
+
+	// TimeFormat is "iso8601", "rfc3339", "rfc3339nano", "epoch" (seconds),
+	// "epochmillis", "epochnanos" or a Go time layout. It defaults to
+	// "iso8601", or to "2006-01-02 15:04:05" when Config.ShortTime is set.
+	TimeFormat string `json:"timeFormat" yaml:"timeFormat"`
+
+	// TimeZone is "UTC", "Local" or an IANA time zone name such as
+	// "Europe/Paris" timestamps are converted to. By default they're left in
+	// the local time zone.
+	TimeZone string `json:"timeZone" yaml:"timeZone"`
+
+	// DurationFormat is "seconds" (default), "millis", "nanos" or "string".
+	DurationFormat string `json:"durationFormat" yaml:"durationFormat"`
+
+	// CallerFormat is "short" (default, package/file:line) or "full".
+	CallerFormat string `json:"callerFormat" yaml:"callerFormat"`
+
+	// LevelFormat is "lowercase" (default) or "capital". Colors are set with
+	// Config.EnableColor.
+	LevelFormat string `json:"levelFormat" yaml:"levelFormat"`
+
+	// location is TimeZone as loaded by resolveTimeZone, which clones keep
+	// so that the time zone database is read once.
+	location *time.Location
+}
+
+const shortTimeLayout = "2006-01-02 15:04:05"
+
+// override returns e with the settings that are set in o replaced.
+func (e EncoderConfig) override(o EncoderConfig) EncoderConfig {
+	fields := []struct{ dst, src *string }{
+		{&e.TimeKey, &o.TimeKey},
+		{&e.LevelKey, &o.LevelKey},
+		{&e.NameKey, &o.NameKey},
+		{&e.CallerKey, &o.CallerKey},
+		{&e.MessageKey, &o.MessageKey},
+		{&e.StacktraceKey, &o.StacktraceKey},
+		{&e.TimeFormat, &o.TimeFormat},
+		{&e.TimeZone, &o.TimeZone},
+		{&e.DurationFormat, &o.DurationFormat},
+		{&e.CallerFormat, &o.CallerFormat},
+		{&e.LevelFormat, &o.LevelFormat},
+	}
+	for _, f := range fields {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	return e
+}
+
+func newEncoder(encoding string, config zapcore.EncoderConfig) zapcore.Encoder {
+	if encoding == "console" {
+		return zapcore.NewConsoleEncoder(config)
+	}
+	return zapcore.NewJSONEncoder(config)
+}
+
+func (e EncoderConfig) validate() []error {
+	var errs []error
+	if _, err := e.timeFormatEncoder(false); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := e.timeLocation(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := e.durationEncoder(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := e.callerEncoder(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := e.levelEncoder(false); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// zapEncoderConfig builds the zap encoder config described by e.
+func (e EncoderConfig) zapEncoderConfig(shortTime, color bool) (zapcore.EncoderConfig, error) {
+	encodeTime, err := e.timeEncoder(shortTime)
+	if err != nil {
+		return zapcore.EncoderConfig{}, err
+	}
+	encodeDuration, err := e.durationEncoder()
+	if err != nil {
+		return zapcore.EncoderConfig{}, err
+	}
+	encodeCaller, err := e.callerEncoder()
+	if err != nil {
+		return zapcore.EncoderConfig{}, err
+	}
+	encodeLevel, err := e.levelEncoder(color)
+	if err != nil {
+		return zapcore.EncoderConfig{}, err
+	}
+	return zapcore.EncoderConfig{
+		TimeKey:        encoderKey(e.TimeKey, "ts"),
+		LevelKey:       encoderKey(e.LevelKey, "level"),
+		NameKey:        encoderKey(e.NameKey, "logger"),
+		CallerKey:      encoderKey(e.CallerKey, "caller"),
+		MessageKey:     encoderKey(e.MessageKey, "msg"),
+		StacktraceKey:  encoderKey(e.StacktraceKey, "stacktrace"),
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    encodeLevel,
+		EncodeTime:     encodeTime,
+		EncodeDuration: encodeDuration,
+		EncodeCaller:   encodeCaller,
+	}, nil
+}
+
+func encoderKey(key, dft string) string {
+	switch key {
+	case "":
+		return dft
+	case "-":
+		// zap leaves out keys that are empty
+		return ""
+	}
+	return key
+}
+
+func (e EncoderConfig) timeEncoder(shortTime bool) (zapcore.TimeEncoder, error) {
+	encodeTime, err := e.timeFormatEncoder(shortTime)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := e.timeLocation()
+	if err != nil || loc == nil {
+		return encodeTime, err
+	}
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		encodeTime(t.In(loc), enc)
+	}, nil
+}
+
+// resolveTimeZone loads TimeZone, unless it was loaded before.
+func (e *EncoderConfig) resolveTimeZone() error {
+	loc, err := e.timeLocation()
+	e.location = loc
+	return err
+}
+
+// timeLocation returns the time zone timestamps are converted to, nil to
+// leave them as they are.
+func (e EncoderConfig) timeLocation() (*time.Location, error) {
+	if e.TimeZone == "" {
+		return nil, nil
+	}
+	// the name of a loaded location is the one it was loaded with
+	if e.location != nil && e.location.String() == e.TimeZone {
+		return e.location, nil
+	}
+	loc, err := time.LoadLocation(e.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid time zone: %w", err)
+	}
+	return loc, nil
+}
+
+func (e EncoderConfig) timeFormatEncoder(shortTime bool) (zapcore.TimeEncoder, error) {
+	var encodeTime zapcore.TimeEncoder
+	switch strings.ToLower(e.TimeFormat) {
+	case "":
+		encodeTime = zapcore.ISO8601TimeEncoder
+		if shortTime {
+			encodeTime = layoutTimeEncoder(shortTimeLayout)
+		}
+	case "iso8601":
+		encodeTime = zapcore.ISO8601TimeEncoder
+	case "rfc3339":
+		encodeTime = zapcore.RFC3339TimeEncoder
+	case "rfc3339nano":
+		encodeTime = zapcore.RFC3339NanoTimeEncoder
+	case "epoch":
+		encodeTime = zapcore.EpochTimeEncoder
+	case "epochmillis":
+		encodeTime = zapcore.EpochMillisTimeEncoder
+	case "epochnanos":
+		encodeTime = zapcore.EpochNanosTimeEncoder
+	default:
+		if !isTimeLayout(e.TimeFormat) {
+			return nil, fmt.Errorf("not a valid time format: %q", e.TimeFormat)
+		}
+		encodeTime = layoutTimeEncoder(e.TimeFormat)
+	}
+	return encodeTime, nil
+}
+
+// isTimeLayout reports whether format contains an element of the reference
+// time, which tells layouts apart from misspelled format names.
+func isTimeLayout(format string) bool {
+	for _, elem := range []string{"2006", "Jan", "01", "02", "Mon", "15", "03", "04", "05"} {
+		if strings.Contains(format, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func layoutTimeEncoder(layout string) zapcore.TimeEncoder {
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		type appendTimeEncoder interface {
+			AppendTimeLayout(time.Time, string)
+		}
+		if enc, ok := enc.(appendTimeEncoder); ok {
+			enc.AppendTimeLayout(t, layout)
+			return
+		}
+		enc.AppendString(t.Format(layout))
+	}
+}
+
+func (e EncoderConfig) durationEncoder() (zapcore.DurationEncoder, error) {
+	switch strings.ToLower(e.DurationFormat) {
+	case "", "seconds":
+		return zapcore.SecondsDurationEncoder, nil
+	case "millis":
+		return zapcore.MillisDurationEncoder, nil
+	case "nanos":
+		return zapcore.NanosDurationEncoder, nil
+	case "string":
+		return zapcore.StringDurationEncoder, nil
+	}
+	return nil, fmt.Errorf("not a valid duration format: %q", e.DurationFormat)
+}
+
+func (e EncoderConfig) callerEncoder() (zapcore.CallerEncoder, error) {
+	switch strings.ToLower(e.CallerFormat) {
+	case "", "short":
+		return zapcore.ShortCallerEncoder, nil
+	case "full":
+		return zapcore.FullCallerEncoder, nil
+	}
+	return nil, fmt.Errorf("not a valid caller format: %q", e.CallerFormat)
+}
+
+func (e EncoderConfig) levelEncoder(color bool) (zapcore.LevelEncoder, error) {
+	switch strings.ToLower(e.LevelFormat) {
+	case "", "lowercase":
+		if color {
+			return zapcore.LowercaseColorLevelEncoder, nil
+		}
+		return zapcore.LowercaseLevelEncoder, nil
+	case "capital":
+		if color {
+			return zapcore.CapitalColorLevelEncoder, nil
+		}
+		return zapcore.CapitalLevelEncoder, nil
+	}
+	return nil, fmt.Errorf("not a valid level format: %q", e.LevelFormat)
+}
@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncoderConfig(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{out}
+	config.Encoder = EncoderConfig{
+		TimeKey:        "@timestamp",
+		LevelKey:       "severity",
+		MessageKey:     "message",
+		CallerKey:      "-",
+		TimeFormat:     "rfc3339nano",
+		TimeZone:       "UTC",
+		DurationFormat: "millis",
+		LevelFormat:    "capital",
+	}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Infow("encoded", "elapsed", 1500*time.Millisecond)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(readLog(t, out)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["severity"] != "INFO" || entry["message"] != "encoded" || entry["elapsed"] != float64(1500) {
+		t.Fatalf("entry = %v", entry)
+	}
+	if _, ok := entry["caller"]; ok {
+		t.Fatalf("caller not left out: %v", entry)
+	}
+	ts, _ := entry["@timestamp"].(string)
+	if parsed, err := time.Parse(time.RFC3339Nano, ts); err != nil || !strings.HasSuffix(ts, "Z") {
+		t.Fatalf("@timestamp = %q (%v, %v)", ts, parsed, err)
+	}
+}
+
+func TestEncoderConfigLayout(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{out}
+	config.CallerSkip = 1
+	config.Encoder = EncoderConfig{TimeFormat: "2006/01/02", TimeZone: "UTC", CallerFormat: "full"}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("layout")
+
+	got := readLog(t, out)
+	if !strings.Contains(got, `"ts":"`+time.Now().UTC().Format("2006/01/02")+`"`) {
+		t.Fatalf("time layout not applied: %s", got)
+	}
+	if !strings.Contains(got, "/encoder_test.go:") || strings.Contains(got, `"caller":"logger/`) {
+		t.Fatalf("full caller not applied: %s", got)
+	}
+}
+
+func TestEncoderTimeZoneLoadedOnce(t *testing.T) {
+	config := NewProductionConfig()
+	config.OutputPaths = []string{filepath.Join(t.TempDir(), "out.log")}
+	config.Encoder.TimeZone = "Europe/Paris"
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := l.(*logger).config.Encoder.location
+	if loc == nil || loc.String() != "Europe/Paris" {
+		t.Fatalf("location = %v", loc)
+	}
+	// derived loggers clone the config without loading the time zone again
+	child := l.With("k", "v").Ctx(context.Background()).(*logger)
+	if child.config.Encoder.location != loc {
+		t.Fatal("time zone loaded again by a derived logger")
+	}
+}
+
+func TestEncoderConfigInvalid(t *testing.T) {
+	config := NewProductionConfig()
+	config.Encoder = EncoderConfig{
+		TimeFormat:     "rfc3339nanos",
+		TimeZone:       "Mars/Olympus",
+		DurationFormat: "fortnights",
+		CallerFormat:   "long",
+		LevelFormat:    "shouting",
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"rfc3339nanos", "Mars/Olympus", "fortnights", "long", "shouting"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %s: %v", want, err)
+		}
+	}
+
+	if err := config.applyEnv("", envLookup(map[string]string{"LOG_TIME_FORMAT": "nope"})); err == nil {
+		t.Fatal("expected env error")
+	}
+	if err := config.applyEnv("", envLookup(map[string]string{"LOG_MESSAGE_KEY": "message"})); err != nil ||
+		config.Encoder.MessageKey != "message" {
+		t.Fatalf("LOG_MESSAGE_KEY not applied: %v", err)
+	}
+}
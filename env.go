@@ -0,0 +1,266 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEnvPrefix is the prefix used by ApplyEnv when it's given an empty one.
+const DefaultEnvPrefix = "LOG"
+
+// envSetter applies the value of the environment variable <prefix>_<name>.
+type envSetter struct {
+	name string
+	set  func(c *Config, value string) error
+}
+
+var envSetters = []envSetter{
+	{"LEVEL", func(c *Config, v string) error { return c.Level.UnmarshalText([]byte(v)) }},
+	{"DEVELOPMENT", envBool(func(c *Config) *bool { return &c.Development })},
+	{"DISABLE_CALLER", envBool(func(c *Config) *bool { return &c.DisableCaller })},
+	{"DISABLE_STACKTRACE", envBool(func(c *Config) *bool { return &c.DisableStacktrace })},
+	{"ENCODING", func(c *Config, v string) error {
+		if err := checkEncoding(v); err != nil {
+			return err
+		}
+		c.Encoding = v
+		return nil
+	}},
+	{"OUTPUT_PATHS", func(c *Config, v string) error {
+		paths, err := envList(v)
+		if err != nil {
+			return err
+		}
+		c.OutputPaths = paths
+		return nil
+	}},
+	{"FALLBACK_PATHS", func(c *Config, v string) error {
+		paths, err := envList(v)
+		if err != nil {
+			return err
+		}
+		c.FallbackPaths = paths
+		return nil
+	}},
+	{"ERROR_OUTPUT_PATHS", func(c *Config, v string) error {
+		paths, err := envList(v)
+		if err != nil {
+			return err
+		}
+		c.ErrorOutputPaths = paths
+		return nil
+	}},
+	{"OUTPUTS", func(c *Config, v string) error {
+		outputs, err := envList(v)
+		if err != nil {
+			return err
+		}
+		c.Outputs = nil
+		for _, output := range outputs {
+			o := OutputConfig{Path: output}
+			if path, level, ok := strings.Cut(output, "="); ok {
+				lvl, err := ParseLevel(level)
+				if err != nil {
+					return err
+				}
+				o = OutputConfig{Path: path, Level: &lvl}
+			}
+			c.Outputs = append(c.Outputs, o)
+		}
+		return nil
+	}},
+	{"FIELDS", func(c *Config, v string) error {
+		pairs, err := envList(v)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("not a valid field %q, want key=value", pair)
+			}
+			c.InitialFields[key] = value
+		}
+		return nil
+	}},
+	{"ENABLE_COLOR", envBool(func(c *Config) *bool { return &c.EnableColor })},
+	{"SHORT_TIME", envBool(func(c *Config) *bool { return &c.ShortTime })},
+	{"CALLER_SKIP", envInt(func(c *Config) *int { return &c.CallerSkip })},
+	{"SAMPLING_DISABLED", envBool(func(c *Config) *bool { return &c.sampling().Disabled })},
+	{"SAMPLING_TICK", func(c *Config, v string) error {
+		tick, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		c.sampling().Tick = tick
+		return nil
+	}},
+	{"SAMPLING_INITIAL", envInt(func(c *Config) *int { return &c.sampling().Initial })},
+	{"SAMPLING_THEREAFTER", envInt(func(c *Config) *int { return &c.sampling().Thereafter })},
+	{"ROTATION_MAX_SIZE", func(c *Config, v string) error { return c.rotation().MaxSize.UnmarshalText([]byte(v)) }},
+	{"ROTATION_INTERVAL", func(c *Config, v string) error { c.rotation().Interval = v; return nil }},
+	{"ROTATION_UTC", envBool(func(c *Config) *bool { return &c.rotation().UTC })},
+	{"ROTATION_COMPRESS", func(c *Config, v string) error { c.rotation().Compress = v; return nil }},
+	{"ROTATION_MAX_BACKUPS", envInt(func(c *Config) *int { return &c.rotation().MaxBackups })},
+	{"ROTATION_MAX_AGE", func(c *Config, v string) error {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		c.rotation().MaxAge = maxAge
+		return nil
+	}},
+	{"ROTATION_MAX_TOTAL_SIZE", func(c *Config, v string) error { return c.rotation().MaxTotalSize.UnmarshalText([]byte(v)) }},
+	{"ASYNC_QUEUE_SIZE", envInt(func(c *Config) *int { return &c.async().QueueSize })},
+	{"ASYNC_POLICY", func(c *Config, v string) error { c.async().Policy = v; return nil }},
+	{"TIME_KEY", envEncoder(func(e *EncoderConfig) *string { return &e.TimeKey })},
+	{"LEVEL_KEY", envEncoder(func(e *EncoderConfig) *string { return &e.LevelKey })},
+	{"NAME_KEY", envEncoder(func(e *EncoderConfig) *string { return &e.NameKey })},
+	{"CALLER_KEY", envEncoder(func(e *EncoderConfig) *string { return &e.CallerKey })},
+	{"MESSAGE_KEY", envEncoder(func(e *EncoderConfig) *string { return &e.MessageKey })},
+	{"STACKTRACE_KEY", envEncoder(func(e *EncoderConfig) *string { return &e.StacktraceKey })},
+	{"TIME_FORMAT", envEncoder(func(e *EncoderConfig) *string { return &e.TimeFormat })},
+	{"TIME_ZONE", envEncoder(func(e *EncoderConfig) *string { return &e.TimeZone })},
+	{"DURATION_FORMAT", envEncoder(func(e *EncoderConfig) *string { return &e.DurationFormat })},
+	{"CALLER_FORMAT", envEncoder(func(e *EncoderConfig) *string { return &e.CallerFormat })},
+	{"LEVEL_FORMAT", envEncoder(func(e *EncoderConfig) *string { return &e.LevelFormat })},
+}
+
+// NewConfigFromEnv returns NewDefaultConfig(fields...), or the preset named
+// by <prefix>_PRESET, with the environment overrides of ApplyEnv applied.
+func NewConfigFromEnv(prefix string, fields ...FieldPair) (*Config, error) {
+	config := NewDefaultConfig(fields...)
+	if err := config.ApplyEnv(prefix); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides fields of c with environment variables named
+// <prefix>_<NAME>, for example LOG_LEVEL=debug, LOG_ENCODING=console,
+// LOG_OUTPUT_PATHS=stdout,app.log, LOG_OUTPUTS=stderr=warn,errors.log=error
+// or LOG_FIELDS=service=api,region=eu.
+// The supported names are:
+//
+//   - PRESET, which replaces c with the named preset (see RegisterPreset)
+//     before the other variables are applied, keeping the initial fields of c
+//   - LEVEL, DEVELOPMENT, DISABLE_CALLER, DISABLE_STACKTRACE, ENCODING,
+//     OUTPUT_PATHS, FALLBACK_PATHS, ERROR_OUTPUT_PATHS, OUTPUTS, FIELDS,
+//     ENABLE_COLOR, SHORT_TIME and CALLER_SKIP
+//   - SAMPLING_DISABLED, SAMPLING_TICK, SAMPLING_INITIAL and
+//     SAMPLING_THEREAFTER for Config.Sampling
+//   - ROTATION_MAX_SIZE, ROTATION_INTERVAL, ROTATION_UTC, ROTATION_COMPRESS,
+//     ROTATION_MAX_BACKUPS, ROTATION_MAX_AGE and ROTATION_MAX_TOTAL_SIZE for
+//     Config.Rotation
+//   - ASYNC_QUEUE_SIZE and ASYNC_POLICY for Config.Async
+//   - TIME_KEY, LEVEL_KEY, NAME_KEY, CALLER_KEY, MESSAGE_KEY, STACKTRACE_KEY,
+//     TIME_FORMAT, TIME_ZONE, DURATION_FORMAT, CALLER_FORMAT and LEVEL_FORMAT
+//     for Config.Encoder
+//
+// Variables that are unset or empty are skipped, and LOG_FIELDS adds to the
+// initial fields instead of replacing them.
+//
+// Malformed values are all reported in the returned error, in which case c
+// is left unchanged.
+func (c *Config) ApplyEnv(prefix string) error {
+	return c.applyEnv(prefix, os.LookupEnv)
+}
+
+func (c *Config) applyEnv(prefix string, lookup func(string) (string, bool)) error {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+
+	env := func(name string) string {
+		value, _ := lookup(prefix + name)
+		return strings.TrimSpace(value)
+	}
+
+	next := c.clone()
+	var errs []error
+	if name := env("PRESET"); name != "" {
+		preset, err := NewConfigFromPreset(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sPRESET: %w", prefix, err))
+		} else {
+			if preset.InitialFields == nil {
+				preset.InitialFields = make(map[string]interface{})
+			}
+			for k, v := range next.InitialFields {
+				preset.InitialFields[k] = v
+			}
+			next = preset
+		}
+	}
+	for _, s := range envSetters {
+		value := env(s.name)
+		if value == "" {
+			continue
+		}
+		if err := s.set(next, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", prefix, s.name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// the zap config of next has the levels from before the environment,
+	// which clone would bring back; it's built again with the logger
+	*c = *next
+	c.zapConfig, c.outputLevels = nil, nil
+	return nil
+}
+
+func envBool(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func envInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+// envEncoder sets a field of the encoder config, checking it on its own so
+// that the error is reported for the right variable.
+func envEncoder(field func(e *EncoderConfig) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		var e EncoderConfig
+		*field(&e) = value
+		if errs := e.validate(); len(errs) > 0 {
+			return errs[0]
+		}
+		*field(&c.Encoder) = value
+		return nil
+	}
+}
+
+// envList splits a comma separated value, rejecting empty elements.
+func envList(value string) ([]string, error) {
+	list := strings.Split(value, ",")
+	for i, v := range list {
+		list[i] = strings.TrimSpace(v)
+		if list[i] == "" {
+			return nil, fmt.Errorf("empty element in %q", value)
+		}
+	}
+	return list, nil
+}
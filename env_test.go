@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+)
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	config := NewDefaultConfig(FieldPair{"version", "v1"})
+	err := config.applyEnv("APP", envLookup(map[string]string{
+		"APP_LEVEL":        "debug",
+		"APP_ENCODING":     "console",
+		"APP_OUTPUT_PATHS": "stdout, app.log",
+		"APP_OUTPUTS":      "stderr=warn,errors.log=error",
+		"APP_FIELDS":       "service=api,region=eu",
+		"APP_ENABLE_COLOR": "true",
+		"APP_CALLER_SKIP":  "",
+		"LOG_LEVEL":        "error",
+	}))
+	if err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	if config.Level != DebugLevel || config.Encoding != "console" || !config.EnableColor {
+		t.Fatalf("env overrides not applied: %+v", config)
+	}
+	if len(config.OutputPaths) != 2 || config.OutputPaths[1] != "app.log" {
+		t.Fatalf("OutputPaths = %v", config.OutputPaths)
+	}
+	if len(config.Outputs) != 2 || config.Outputs[0].Path != "stderr" || *config.Outputs[1].Level != ErrorLevel {
+		t.Fatalf("Outputs = %+v", config.Outputs)
+	}
+	if config.InitialFields["service"] != "api" || config.InitialFields["region"] != "eu" ||
+		config.InitialFields["version"] != "v1" {
+		t.Fatalf("InitialFields = %v", config.InitialFields)
+	}
+	if config.CallerSkip != 2 {
+		t.Fatalf("empty variable should be skipped, CallerSkip = %d", config.CallerSkip)
+	}
+}
+
+func TestConfigApplyEnvBuilt(t *testing.T) {
+	// as returned by GetConfig
+	config := NewProductionConfig()
+	if err := config.buildZapConfig(); err != nil {
+		t.Fatal(err)
+	}
+	err := config.applyEnv("", envLookup(map[string]string{"LOG_LEVEL": "debug"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lvl := config.clone().Level; lvl != DebugLevel {
+		t.Fatalf("level of a copy = %s", lvl)
+	}
+}
+
+func TestConfigApplyEnvErrors(t *testing.T) {
+	config := NewDefaultConfig()
+	err := config.applyEnv("", envLookup(map[string]string{
+		"LOG_LEVEL":        "loud",
+		"LOG_ENCODING":     "xml",
+		"LOG_FIELDS":       "service",
+		"LOG_SHORT_TIME":   "yes please",
+		"LOG_OUTPUT_PATHS": "stdout,,stderr",
+	}))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, name := range []string{"LOG_LEVEL", "LOG_ENCODING", "LOG_FIELDS", "LOG_SHORT_TIME", "LOG_OUTPUT_PATHS"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error does not report %s: %v", name, err)
+		}
+	}
+	if config.Level != InfoLevel || config.Encoding != "json" {
+		t.Fatalf("config changed on error: %+v", config)
+	}
+}
+
+func TestNewConfigFromEnv(t *testing.T) {
+	t.Setenv("TESTLOG_LEVEL", "warn")
+	config, err := NewConfigFromEnv("TESTLOG", FieldPair{"service", "api"})
+	if err != nil {
+		t.Fatalf("NewConfigFromEnv: %v", err)
+	}
+	if config.Level != WarnLevel || config.InitialFields["service"] != "api" {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+}
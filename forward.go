@@ -0,0 +1,507 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The fluent outputs send entries to Fluentd or Fluent Bit with the Forward
+// protocol, fluent over TCP and fluent+unix over a unix socket:
+//
+//	fluent://localhost:24224?tag=app.{level}
+//	fluent+unix:///var/run/fluent.sock?ack=true
+//
+// The port is 24224 by default. The query parameters are batchSize,
+// batchWait, timeout, retries, backoff and maxBackoff as for the http
+// outputs, and:
+//
+//   - tag: the tag of the entries, the name of the executable by default.
+//     {level}, {logger} and {hostname} are replaced by the level, the
+//     logger name and the host name, other {key} placeholders by the value of
+//     the field key of the entry.
+//   - compress: "gzip" sends CompressedPackedForward messages, "none"
+//     (default) PackedForward ones
+//   - ack: "true" asks the server to acknowledge every chunk, which is sent
+//     again, possibly on a new connection, until it is
+//
+// The records have the fields of the entries, along with the message, level,
+// caller, logger name and stacktrace under the keys of the encoder settings
+// of the output, and the time of the entry as the event time.
+const (
+	FluentScheme     = "fluent"
+	FluentUnixScheme = "fluent+unix"
+)
+
+const defaultFluentPort = "24224"
+
+var fluentPlaceholder = regexp.MustCompile(`\{[^{}]+\}`)
+
+func init() {
+	registerRecordSink(FluentScheme, newFluentSink)
+	registerRecordSink(FluentUnixScheme, newFluentSink)
+}
+
+type fluentSink struct {
+	*batcher
+	opts    httpOptions
+	network string
+	addr    string
+	tag     string
+	ack     bool
+	keys    zapcore.EncoderConfig
+
+	conn net.Conn // only used by the batcher
+}
+
+func newFluentSink(u *url.URL) (recordSink, error) {
+	compress := u.Query().Has("compress")
+	o, endpoint, err := parseHTTPOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	o.gzip = o.gzip && compress
+	s := &fluentSink{
+		opts: o,
+		tag:  filepath.Base(os.Args[0]),
+		keys: zapcore.EncoderConfig{
+			MessageKey: "msg", LevelKey: "level", CallerKey: "caller",
+			NameKey: "logger", StacktraceKey: "stacktrace",
+		},
+	}
+	for key, values := range endpoint.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "tag":
+			s.tag = value
+		case "ack":
+			if s.ack, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("output %s: ack: %w", outputName(u), err)
+			}
+		default:
+			return nil, fmt.Errorf("output %s: %s: unknown parameter", outputName(u), key)
+		}
+	}
+	if len(o.header) > 0 {
+		return nil, fmt.Errorf("output %s: header, token and contentType only apply to HTTP", outputName(u))
+	}
+
+	if u.Scheme == FluentUnixScheme {
+		s.network, s.addr = "unix", u.Path
+	} else {
+		s.network, s.addr = "tcp", u.Host
+		if _, _, err := net.SplitHostPort(s.addr); err != nil {
+			s.addr = net.JoinHostPort(u.Hostname(), defaultFluentPort)
+		}
+	}
+	if s.addr == "" || s.addr == ":"+defaultFluentPort {
+		return nil, fmt.Errorf("output %s: no address", outputName(u))
+	}
+	s.batcher = newBatcher(int(o.batchSize), o.batchWait, outputName(u), s.send)
+	return s, nil
+}
+
+func (s *fluentSink) setEncoder(_ string, config zapcore.EncoderConfig) {
+	s.keys = config
+}
+
+// Write sends p as the message of an InfoLevel entry.
+func (s *fluentSink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: msg}}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeRecord queues r with its Line replaced by the [time, record] entry.
+func (s *fluentSink) writeRecord(r *record) error {
+	entry := make(map[string]interface{}, len(r.Fields)+5)
+	for k, v := range r.Fields {
+		entry[k] = v
+	}
+	set := func(key string, value interface{}) {
+		if key != "" && key != zapcore.OmitKey {
+			entry[key] = value
+		}
+	}
+	set(s.keys.MessageKey, r.Message)
+	set(s.keys.LevelKey, r.Level.String())
+	if r.Caller.Defined {
+		set(s.keys.CallerKey, r.Caller.TrimmedPath())
+	}
+	if r.LoggerName != "" {
+		set(s.keys.NameKey, r.LoggerName)
+	}
+	if r.Stack != "" {
+		set(s.keys.StacktraceKey, r.Stack)
+	}
+
+	b := msgpackArray(nil, 2)
+	b = msgpackEventTime(b, r.Time)
+	r.Line = msgpackAppend(b, entry)
+	s.add(r)
+	return nil
+}
+
+// tagOf returns the tag of r.
+func (s *fluentSink) tagOf(r *record) string {
+	return fluentPlaceholder.ReplaceAllStringFunc(s.tag, func(p string) string {
+		switch key := p[1 : len(p)-1]; key {
+		case "level":
+			return r.Level.String()
+		case "logger":
+			return r.LoggerName
+		case "hostname":
+			return hostname
+		default:
+			if v, ok := r.Fields[key]; ok {
+				return recordValue(v)
+			}
+			return ""
+		}
+	})
+}
+
+func (s *fluentSink) send(ctx context.Context, batch []*record) error {
+	var tags []string
+	entries := make(map[string][]byte)
+	records := make(map[string][]*record)
+	for _, r := range batch {
+		tag := s.tagOf(r)
+		if _, ok := entries[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		entries[tag] = append(entries[tag], r.Line...)
+		records[tag] = append(records[tag], r)
+	}
+
+	var dropped []*record
+	var lastErr error
+	for _, tag := range tags {
+		msg, chunk := s.message(tag, entries[tag], len(records[tag]))
+		for attempt := 0; ; attempt++ {
+			err := s.forward(ctx, msg, chunk)
+			if err == nil {
+				break
+			}
+			// the connection may be broken in any way
+			if s.conn != nil {
+				_ = s.conn.Close()
+				s.conn = nil
+			}
+			if attempt >= s.opts.retries || !sleep(ctx, s.opts.delay(attempt)) {
+				dropped = append(dropped, records[tag]...)
+				lastErr = err
+				break
+			}
+		}
+	}
+	if len(dropped) > 0 {
+		return &partialError{dropped: dropped, err: lastErr}
+	}
+	return nil
+}
+
+// message returns the PackedForward message of the entries of tag, and its
+// chunk id when acks are requested.
+func (s *fluentSink) message(tag string, entries []byte, count int) ([]byte, string) {
+	options := map[string]interface{}{"size": count}
+	if s.opts.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(entries)
+		_ = zw.Close()
+		entries = buf.Bytes()
+		options["compressed"] = "gzip"
+	}
+	var chunk string
+	if s.ack {
+		id := make([]byte, 16)
+		_, _ = rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		options["chunk"] = chunk
+	}
+	b := msgpackArray(nil, 3)
+	b = msgpackString(b, tag)
+	b = msgpackBin(b, entries)
+	return msgpackAppend(b, options), chunk
+}
+
+// forward writes msg, and waits for the ack of chunk if it's set. It gives
+// up when ctx is done.
+func (s *fluentSink) forward(ctx context.Context, msg []byte, chunk string) error {
+	if s.conn == nil {
+		dialer := net.Dialer{Timeout: s.opts.timeout}
+		conn, err := dialer.DialContext(ctx, s.network, s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	conn := s.conn
+	_ = conn.SetDeadline(time.Now().Add(s.opts.timeout))
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	// the connection is reused with another context afterwards
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+	ack, err := readFluentAck(conn)
+	if err != nil {
+		return fmt.Errorf("reading ack: %w", err)
+	}
+	if ack != chunk {
+		return fmt.Errorf("ack %q for chunk %q", ack, chunk)
+	}
+	return nil
+}
+
+// Close sends the pending entries and closes the connection.
+func (s *fluentSink) Close() error {
+	err := s.batcher.Close()
+	if s.conn != nil {
+		err = s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+var errFluentAck = errors.New("unexpected response")
+
+// readFluentAck reads a {"ack": chunk} response, the only msgpack the
+// server sends.
+func readFluentAck(r io.Reader) (string, error) {
+	var header [1]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", err
+	}
+	n := 0
+	switch {
+	case header[0]&0xf0 == 0x80:
+		n = int(header[0] & 0x0f)
+	case header[0] == 0xde:
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint16(size[:]))
+	default:
+		return "", errFluentAck
+	}
+	ack := ""
+	for i := 0; i < n; i++ {
+		key, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		value, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		if key == "ack" {
+			ack = value
+		}
+	}
+	return ack, nil
+}
+
+func readMsgpackString(r io.Reader) (string, error) {
+	var header [1]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", err
+	}
+	var n int
+	switch header[0] {
+	case 0xd9, 0xda, 0xdb:
+		size := make([]byte, 1<<(header[0]-0xd9))
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "", err
+		}
+		for _, c := range size {
+			n = n<<8 | int(c)
+		}
+	default:
+		if header[0]&0xe0 != 0xa0 {
+			return "", errFluentAck
+		}
+		n = int(header[0] & 0x1f)
+	}
+	if n > 1<<16 {
+		return "", errFluentAck
+	}
+	s := make([]byte, n)
+	_, err := io.ReadFull(r, s)
+	return string(s), err
+}
+
+// Msgpack encoding.
+
+func msgpackArray(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func msgpackMap(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+func msgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func msgpackBin(b []byte, v []byte) []byte {
+	switch n := len(v); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, v...)
+}
+
+func msgpackInt(b []byte, v int64) []byte {
+	if v >= 0 {
+		return msgpackUint(b, uint64(v))
+	}
+	if v >= -32 {
+		return append(b, byte(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func msgpackUint(b []byte, v uint64) []byte {
+	if v < 128 {
+		return append(b, byte(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+}
+
+// msgpackEventTime appends t as the EventTime extension of Fluentd.
+func msgpackEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()))
+	return binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+}
+
+// msgpackAppend appends v, a value decoded by a zapcore.MapObjectEncoder.
+func msgpackAppend(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case string:
+		return msgpackString(b, v)
+	case []byte:
+		return msgpackBin(b, v)
+	case int:
+		return msgpackInt(b, int64(v))
+	case int8:
+		return msgpackInt(b, int64(v))
+	case int16:
+		return msgpackInt(b, int64(v))
+	case int32:
+		return msgpackInt(b, int64(v))
+	case int64:
+		return msgpackInt(b, v)
+	case uint:
+		return msgpackUint(b, uint64(v))
+	case uint8:
+		return msgpackUint(b, uint64(v))
+	case uint16:
+		return msgpackUint(b, uint64(v))
+	case uint32:
+		return msgpackUint(b, uint64(v))
+	case uint64:
+		return msgpackUint(b, v)
+	case uintptr:
+		return msgpackUint(b, uint64(v))
+	case float32:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(float64(v)))
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+	case []interface{}:
+		b = msgpackArray(b, len(v))
+		for _, e := range v {
+			b = msgpackAppend(b, e)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = msgpackMap(b, len(keys))
+		for _, k := range keys {
+			b = msgpackAppend(msgpackString(b, k), v[k])
+		}
+		return b
+	case time.Time:
+		return msgpackString(b, v.Format(time.RFC3339Nano))
+	case time.Duration:
+		return msgpackString(b, v.String())
+	}
+	return msgpackString(b, recordValue(v))
+}
@@ -0,0 +1,284 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readMsgpack reads a msgpack value, with ext values as []byte.
+func readMsgpack(t *testing.T, r *bufio.Reader) interface{} {
+	t.Helper()
+	c, err := r.ReadByte()
+	if err != nil {
+		panic(err) // connection closed
+	}
+	next := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	size := func(n int) int {
+		v := 0
+		for _, c := range next(n) {
+			v = v<<8 | int(c)
+		}
+		return v
+	}
+	readArray := func(n int) []interface{} {
+		a := make([]interface{}, n)
+		for i := range a {
+			a[i] = readMsgpack(t, r)
+		}
+		return a
+	}
+	readMap := func(n int) map[string]interface{} {
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k := readMsgpack(t, r).(string)
+			m[k] = readMsgpack(t, r)
+		}
+		return m
+	}
+	switch {
+	case c < 0x80:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	case c&0xf0 == 0x80:
+		return readMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return readArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return string(next(int(c & 0x1f)))
+	}
+	switch c {
+	case 0xc0:
+		return nil
+	case 0xc2, 0xc3:
+		return c == 0xc3
+	case 0xc4, 0xc5, 0xc6:
+		return next(size(1 << (c - 0xc4)))
+	case 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(next(8)))
+	case 0xcf:
+		return int64(binary.BigEndian.Uint64(next(8)))
+	case 0xd3:
+		return int64(binary.BigEndian.Uint64(next(8)))
+	case 0xd7:
+		return next(9)
+	case 0xd9, 0xda, 0xdb:
+		return string(next(size(1 << (c - 0xd9))))
+	case 0xdc:
+		return readArray(size(2))
+	case 0xde:
+		return readMap(size(2))
+	}
+	t.Fatalf("unexpected msgpack byte %#x", c)
+	return nil
+}
+
+// forwardMessage is a PackedForward message received by a fakeForwarder.
+type forwardMessage struct {
+	tag     string
+	entries [][]interface{}
+	options map[string]interface{}
+}
+
+// fakeForwarder is a Forward server, which drops the first connections
+// without acknowledging the chunks.
+type fakeForwarder struct {
+	t     *testing.T
+	ln    net.Listener
+	drops int
+
+	mu       sync.Mutex
+	conns    int
+	messages []forwardMessage
+}
+
+func newFakeForwarder(t *testing.T, network, addr string, drops int) *fakeForwarder {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeForwarder{t: t, ln: ln, drops: drops}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeForwarder) serve(conn net.Conn) {
+	defer conn.Close()
+	defer func() { _ = recover() }() // connection closed
+	f.mu.Lock()
+	f.conns++
+	drop := f.conns <= f.drops
+	f.mu.Unlock()
+
+	r := bufio.NewReader(conn)
+	for {
+		msg := readMsgpack(f.t, r).([]interface{})
+		m := forwardMessage{tag: msg[0].(string), options: msg[2].(map[string]interface{})}
+		if drop {
+			return
+		}
+		data := msg[1].([]byte)
+		if m.options["compressed"] == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				f.t.Error(err)
+				return
+			}
+			data, _ = io.ReadAll(zr)
+		}
+		entries := bufio.NewReader(bytes.NewReader(data))
+		for {
+			if _, err := entries.Peek(1); err != nil {
+				break
+			}
+			m.entries = append(m.entries, readMsgpack(f.t, entries).([]interface{}))
+		}
+		f.mu.Lock()
+		f.messages = append(f.messages, m)
+		f.mu.Unlock()
+		if chunk, ok := m.options["chunk"].(string); ok {
+			_, _ = conn.Write(msgpackAppend(nil, map[string]interface{}{"ack": chunk}))
+		}
+	}
+}
+
+func (f *fakeForwarder) received() []forwardMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]forwardMessage(nil), f.messages...)
+}
+
+// messageKey names the message key of the entries "message", as read by the
+// tests.
+func messageKey(config *Config) {
+	config.Encoder.MessageKey = "message"
+}
+
+func TestForwardPacked(t *testing.T) {
+	f := newFakeForwarder(t, "tcp", "127.0.0.1:0", 1)
+	l := newOutputTestLogger(t, "fluent://"+f.ln.Addr().String()+"?tag=app.{level}.{service}&ack=true&backoff=1ms", messageKey)
+	l = l.With("service", "api")
+	l.Infow("first", "user", "bob")
+	l.Info("second")
+	l.Error("third")
+	if err := syncLogger(l); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := f.received()
+	if len(messages) != 2 || messages[0].tag != "app.info.api" || messages[1].tag != "app.error.api" {
+		t.Fatalf("messages = %+v", messages)
+	}
+	info := messages[0]
+	if len(info.entries) != 2 || info.options["size"] != int64(2) || info.options["chunk"] == nil {
+		t.Fatalf("message = %+v", info)
+	}
+	eventTime := info.entries[0][0].([]byte)
+	if eventTime[0] != 0 || time.Since(time.Unix(int64(binary.BigEndian.Uint32(eventTime[1:])), 0)) > time.Minute {
+		t.Fatalf("event time = %x", eventTime)
+	}
+	record := info.entries[0][1].(map[string]interface{})
+	if record["message"] != "first" || record["level"] != "info" || record["user"] != "bob" || record["service"] != "api" {
+		t.Fatalf("record = %v", record)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns != 2 {
+		t.Fatalf("%d connections, want a reconnection after the dropped one", f.conns)
+	}
+}
+
+func TestForwardUnixCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fluent.sock")
+	f := newFakeForwarder(t, "unix", path, 0)
+	l := newOutputTestLogger(t, "fluent+unix://"+path+"?compress=gzip", messageKey)
+	l.Warn("compressed")
+	if err := syncLogger(l); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	messages := f.received()
+	if len(messages) != 1 || messages[0].options["compressed"] != "gzip" {
+		t.Fatalf("messages = %+v", messages)
+	}
+}
+
+func TestForwardCloseEndsRetries(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the forwarder reads the messages, but never acks them
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		accepted <- struct{}{}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	defer func(timeout time.Duration) { batcherCloseTimeout = timeout }(batcherCloseTimeout)
+	batcherCloseTimeout = 100 * time.Millisecond
+	sink, err := openRecordSink("fluent://" + ln.Addr().String() + "?ack=true&timeout=1h&backoff=1h&maxBackoff=1h&batchWait=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = sink.Write([]byte("waiting\n"))
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection")
+	}
+	closeWithin(t, sink, 5*time.Second)
+	if e := <-Diagnostics(); e.Dropped != 1 {
+		t.Fatalf("diagnostic = %v", e)
+	}
+}
+
+func TestForwardURL(t *testing.T) {
+	for _, u := range []string{
+		"fluent://localhost?ack=maybe",
+		"fluent://localhost?token=x",
+		"fluent://localhost?format=json",
+		"fluent+unix://",
+	} {
+		if s, err := openRecordSink(u); err == nil {
+			_ = s.Close()
+			t.Errorf("%s: expected error", u)
+		}
+	}
+}
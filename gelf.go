@@ -0,0 +1,288 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The gelf outputs send entries to Graylog as GELF 1.1 messages, gelf+udp
+// over UDP and gelf+tcp over TCP:
+//
+//	gelf+udp://graylog:12201?compress=zlib
+//	gelf+tcp://graylog:12201
+//
+// The port is 12201 by default. Over UDP, messages are compressed with
+// compress, "gzip" (default), "zlib" or "none", and split in chunks of
+// chunkSize bytes, 1420 by default, when they're larger. Over TCP, they're
+// sent uncompressed and terminated by a null byte.
+//
+// The short message is the entry message and the full message the
+// stacktrace. The level is the syslog severity of the level, and the fields
+// of the entry are sent as additional fields, prefixed with an underscore,
+// along with _file, _line and _function from the caller and _logger.
+//
+// Messages are sent by the log calls. When the server can't be reached, they
+// fail without connecting again for gelfRedialDelay, 1s.
+const (
+	GELFUDPScheme = "gelf+udp"
+	GELFTCPScheme = "gelf+tcp"
+)
+
+const (
+	defaultGELFPort      = "12201"
+	defaultGELFChunkSize = 1420
+	maxGELFChunks        = 128
+)
+
+var gelfFieldName = regexp.MustCompile(`[^\w.\-]`)
+
+// gelfTimeout bounds connecting to the server and writing a message over TCP.
+// Tests shorten it.
+var gelfTimeout = 5 * time.Second
+
+// gelfRedialDelay is the time the messages fail without connecting after a
+// failed connection, so that a server down doesn't hold every log call for
+// gelfTimeout. Tests shorten it.
+var gelfRedialDelay = time.Second
+
+// gelfReserved are the additional fields the sink sets itself.
+var gelfReserved = map[string]bool{"_id": true, "_file": true, "_line": true, "_function": true, "_logger": true}
+
+func init() {
+	registerRecordSink(GELFUDPScheme, newGELFSink)
+	registerRecordSink(GELFTCPScheme, newGELFSink)
+}
+
+type gelfSink struct {
+	network   string
+	addr      string
+	compress  string
+	chunkSize int
+
+	mu      sync.Mutex
+	conn    net.Conn
+	dialErr error     // of the last failed connection
+	redial  time.Time // when to connect again after dialErr
+}
+
+func newGELFSink(u *url.URL) (recordSink, error) {
+	s := &gelfSink{
+		network:   strings.TrimPrefix(u.Scheme, "gelf+"),
+		addr:      u.Host,
+		compress:  "gzip",
+		chunkSize: defaultGELFChunkSize,
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("output %s: no host", outputName(u))
+	}
+	if _, _, err := net.SplitHostPort(s.addr); err != nil {
+		s.addr = net.JoinHostPort(u.Hostname(), defaultGELFPort)
+	}
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		var err error
+		switch key {
+		case "compress":
+			switch value {
+			case "gzip", "zlib", "none":
+				s.compress = value
+			default:
+				err = errors.New(`want "gzip", "zlib" or "none"`)
+			}
+		case "chunkSize":
+			s.chunkSize, err = strconv.Atoi(value)
+			if err == nil && s.chunkSize <= 12 {
+				err = errors.New("must be larger than the chunk header")
+			}
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %s: %w", outputName(u), key, err)
+		}
+	}
+	return s, nil
+}
+
+// Write sends p as an InfoLevel message.
+func (s *gelfSink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: msg}}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *gelfSink) writeRecord(r *record) error {
+	msg, err := gelfMessage(r)
+	if err != nil {
+		return err
+	}
+	var packets [][]byte
+	if s.network == "udp" {
+		if packets, err = s.chunks(msg); err != nil {
+			return err
+		}
+	} else {
+		packets = [][]byte{append(msg, 0)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a connection that was closed by the server in the meantime only fails
+	// on the write, so it's retried once on a new connection
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if time.Now().Before(s.redial) {
+				return s.dialErr
+			}
+			if s.conn, err = net.DialTimeout(s.network, s.addr, gelfTimeout); err != nil {
+				s.dialErr, s.redial = err, time.Now().Add(gelfRedialDelay)
+				return err
+			}
+		}
+		if s.network == "tcp" {
+			// a server that stops reading would block every log call
+			_ = s.conn.SetWriteDeadline(time.Now().Add(gelfTimeout))
+		}
+		for _, p := range packets {
+			if _, err = s.conn.Write(p); err != nil {
+				break
+			}
+		}
+		if err == nil || attempt == 1 {
+			return err
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// chunks returns the datagrams of msg, compressed and chunked.
+func (s *gelfSink) chunks(msg []byte) ([][]byte, error) {
+	var buf bytes.Buffer
+	switch s.compress {
+	case "gzip":
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(msg)
+		_ = zw.Close()
+		msg = buf.Bytes()
+	case "zlib":
+		zw := zlib.NewWriter(&buf)
+		_, _ = zw.Write(msg)
+		_ = zw.Close()
+		msg = buf.Bytes()
+	}
+	if len(msg) <= s.chunkSize {
+		return [][]byte{msg}, nil
+	}
+
+	// the chunks have a 12 bytes header: the magic bytes, the id of the
+	// message, the sequence number and the number of chunks
+	size := s.chunkSize - 12
+	count := (len(msg) + size - 1) / size
+	if count > maxGELFChunks {
+		return nil, fmt.Errorf("GELF message of %d bytes needs more than %d chunks", len(msg), maxGELFChunks)
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, msg[i*size:end]...))
+	}
+	return chunks, nil
+}
+
+func (s *gelfSink) Sync() error {
+	return nil
+}
+
+func (s *gelfSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// gelfMessage returns r as a GELF 1.1 message.
+func gelfMessage(r *record) ([]byte, error) {
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          hostname,
+		"short_message": r.Message,
+		"timestamp":     float64(r.Time.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         syslogSeverity(r.Level),
+	}
+	if r.Stack != "" {
+		msg["full_message"] = r.Stack
+	}
+	for k, v := range r.Fields {
+		msg[gelfAdditionalField(k)] = gelfValue(v)
+	}
+	if r.Caller.Defined {
+		msg["_file"] = r.Caller.File
+		msg["_line"] = r.Caller.Line
+		if r.Caller.Function != "" {
+			msg["_function"] = r.Caller.Function
+		}
+	}
+	if r.LoggerName != "" {
+		msg["_logger"] = r.LoggerName
+	}
+	return json.Marshal(msg)
+}
+
+// gelfAdditionalField returns the name of the additional field of the field
+// k: k prefixed with an underscore, with the characters GELF doesn't allow
+// replaced. _id and the fields the sink sets are prefixed once more.
+func gelfAdditionalField(k string) string {
+	name := "_" + gelfFieldName.ReplaceAllString(k, "_")
+	if gelfReserved[name] {
+		return "_" + name
+	}
+	return name
+}
+
+// gelfValue returns v as a string or a number, the only types of additional
+// fields. NaN and infinite numbers, which JSON can't encode, are strings.
+func gelfValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v
+	case float32:
+		if f := float64(v); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return v
+		}
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return v
+		}
+	}
+	return recordValue(v)
+}
@@ -0,0 +1,245 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGELFUDPChunks(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := newOutputTestLogger(t, "gelf+udp://"+conn.LocalAddr().String()+"?chunkSize=64&compress=gzip")
+	l.Errorw("disk full", "disk", "sda", "free", 3, "id", "x", "a b", true)
+
+	var chunks [][]byte
+	buf := make([]byte, 4096)
+	for count := 1; len(chunks) < count; {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 64 || buf[0] != 0x1e || buf[1] != 0x0f {
+			t.Fatalf("chunk of %d bytes with header % x", n, buf[:2])
+		}
+		if chunks == nil {
+			count = int(buf[11])
+			chunks = make([][]byte, 0, count)
+		}
+		if int(buf[10]) != len(chunks) {
+			t.Fatalf("chunk %d received as %d", buf[10], len(chunks))
+		}
+		chunks = append(chunks, append([]byte(nil), buf[12:n]...))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(bytes.Join(chunks, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.NewDecoder(zr).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["version"] != "1.1" || msg["short_message"] != "disk full" || msg["level"] != 3.0 ||
+		msg["_disk"] != "sda" || msg["_free"] != 3.0 || msg["__id"] != "x" || msg["_a_b"] != "true" ||
+		msg["host"] != hostname || msg["full_message"] == nil {
+		t.Fatalf("message = %v", msg)
+	}
+	if file, _ := msg["_file"].(string); file == "" || msg["_line"] == nil {
+		t.Fatalf("message without caller: %v", msg)
+	}
+}
+
+func TestGELFUDPZlib(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := newOutputTestLogger(t, "gelf+udp://"+conn.LocalAddr().String()+"?compress=zlib")
+	l.Info("started")
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(buf[:n]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(zr)
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["short_message"] != "started" || msg["level"] != 6.0 {
+		t.Fatalf("message = %v", msg)
+	}
+}
+
+func TestGELFTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	l := newOutputTestLogger(t, "gelf+tcp://"+ln.Addr().String())
+	l.Info("first")
+	l.Warnw("second", "k", "v")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var msgs []map[string]interface{}
+	for i := 0; i < 2; i++ {
+		data, err := r.ReadBytes(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data[:len(data)-1], &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if msgs[0]["short_message"] != "first" || msgs[1]["short_message"] != "second" ||
+		msgs[1]["level"] != 4.0 || msgs[1]["_k"] != "v" {
+		t.Fatalf("messages = %v", msgs)
+	}
+}
+
+func TestGELFTCPWriteTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the server accepts the connection but never reads from it
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(10 * time.Second)
+		}
+	}()
+	defer func(timeout time.Duration) { gelfTimeout = timeout }(gelfTimeout)
+	gelfTimeout = 100 * time.Millisecond
+
+	u, _ := url.Parse("gelf+tcp://" + ln.Addr().String())
+	sink, err := newGELFSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sink.Close()
+		msg := []byte(strings.Repeat("x", 64<<10))
+		for start := time.Now(); time.Since(start) < time.Second; {
+			_, _ = sink.Write(msg)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a write is blocked")
+	}
+}
+
+func TestGELFRedialDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	defer func(delay time.Duration) { gelfRedialDelay = delay }(gelfRedialDelay)
+	gelfRedialDelay = 200 * time.Millisecond
+
+	u, _ := url.Parse("gelf+tcp://" + addr)
+	sink, err := newGELFSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if _, err := sink.Write([]byte("down\n")); err == nil {
+		t.Fatal("expected error")
+	}
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	if _, err := sink.Write([]byte("before the delay\n")); err == nil {
+		t.Fatal("connected again before gelfRedialDelay")
+	}
+	time.Sleep(gelfRedialDelay)
+	if _, err := sink.Write([]byte("after the delay\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGELFMessageFields(t *testing.T) {
+	r := &record{
+		Entry: zapcore.Entry{
+			Message:    "fields",
+			LoggerName: "app",
+			Caller:     zapcore.EntryCaller{Defined: true, File: "main.go", Line: 7, Function: "main.main"},
+		},
+		Fields: map[string]interface{}{
+			"file": "report.csv", "line": 3, "function": "parse", "logger": "csv",
+			"ratio": math.NaN(), "limit": math.Inf(1), "small": float32(0.5),
+		},
+	}
+	data, err := gelfMessage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["_file"] != "main.go" || msg["_line"] != 7.0 || msg["_function"] != "main.main" || msg["_logger"] != "app" ||
+		msg["__file"] != "report.csv" || msg["__line"] != 3.0 || msg["__function"] != "parse" || msg["__logger"] != "csv" {
+		t.Fatalf("message = %v", msg)
+	}
+	if msg["_ratio"] != "NaN" || msg["_limit"] != "+Inf" || msg["_small"] != 0.5 {
+		t.Fatalf("message = %v", msg)
+	}
+}
+
+func TestGELFURLErrors(t *testing.T) {
+	for _, path := range []string{
+		"gelf+udp://localhost?compress=lz4",
+		"gelf+udp://localhost?chunkSize=8",
+		"gelf+tcp://localhost?token=x",
+		"gelf+tcp://:12201",
+	} {
+		u, _ := url.Parse(path)
+		if _, err := newGELFSink(u); err == nil || !strings.HasPrefix(err.Error(), "output "+outputName(u)+": ") {
+			t.Errorf("%s: err = %v", path, err)
+		}
+	}
+}
@@ -1,29 +1,169 @@
 package logger
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
 var (
 	defaultConfig = NewDefaultConfig()
-	l             = newLogger(defaultConfig)
+
+	// global is read without locking by the package level functions, so they
+	// always see a complete logger. globalMu serializes the writers, which
+	// read the current logger before replacing it.
+	global   atomic.Pointer[globalLogger]
+	globalMu sync.Mutex
 )
 
+func init() {
+	storeGlobal(newLogger(defaultConfig), true)
+}
+
+// globalLogger is the global Logger as it was set, along with the methods
+// the package level functions need on top of the Logger interface.
+type globalLogger struct {
+	Logger
+	ext extendedLogger
+	l   *logger // nil when Logger isn't implemented by this package
+
+	// built is set when the package built the logger, rather than
+	// ReplaceGlobal. SetConfig only reloads those in place.
+	built bool
+}
+
+// extendedLogger is what the package level functions use beyond Logger.
+type extendedLogger interface {
+	Logger
+	DPanic(args ...interface{})
+	Panic(args ...interface{})
+	DPanicf(template string, args ...interface{})
+	Panicf(template string, args ...interface{})
+	DPanicw(msg string, keysAndValues ...interface{})
+	Panicw(msg string, keysAndValues ...interface{})
+	Sync() error
+	Level() Level
+	GetZapLogger() *zap.Logger
+}
+
+func current() *globalLogger {
+	return global.Load()
+}
+
+func storeGlobal(lg Logger, built bool) {
+	g := &globalLogger{Logger: lg, built: built}
+	switch v := lg.(type) {
+	case *logger:
+		if v == nil {
+			v = newNopLogger()
+			g.Logger = v
+		}
+		g.ext, g.l = v, v
+	case extendedLogger:
+		g.ext = v
+	case nil:
+		nop := newNopLogger()
+		g.Logger, g.ext, g.l = nop, nop, nop
+	default:
+		g.ext = foreignLogger{lg}
+	}
+	global.Store(g)
+}
+
+var errForeignGlobal = errors.New("the global logger isn't implemented by this package")
+
+// ReplaceGlobal makes lg the global logger used by the package level
+// functions, GetLogger and GetZapLogger, and returns a function restoring the
+// previous one. It's meant for tests, which can install their own Logger and
+// defer the restore. A nil lg installs a logger that discards everything.
+// SetConfig then builds a new global logger, leaving lg as it is.
+func ReplaceGlobal(lg Logger) (restore func()) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	prev := current()
+	storeGlobal(lg, false)
+	return func() {
+		globalMu.Lock()
+		defer globalMu.Unlock()
+		global.Store(prev)
+	}
+}
+
+// SetConfig rebuilds the global logger from config. Loggers derived from the
+// global logger with With, Ctx or WithTraceID switch over to the outputs,
+// levels, encoders and fields of config as well, but keep the caller,
+// stacktrace, development and ErrorOutputPaths settings they were derived
+// with. If config is invalid, the error is written to stderr and the current
+// logger is kept; use SetConfigE to handle it instead.
 func SetConfig(config *Config) {
-	l = newLogger(config)
+	if err := SetConfigE(config); err != nil {
+		fmt.Fprintf(os.Stderr, "error on build zap logger (%s)", err)
+	}
 }
 
+// SetConfigE is like SetConfig but returns the error when config is invalid
+// or the logger can't be built, keeping the current global logger.
+func SetConfigE(config *Config) error {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return setConfigLocked(config)
+}
+
+func setConfigLocked(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	var nl *logger
+	var err error
+	if g := current(); g.built {
+		nl, err = g.l.reload(config)
+	} else {
+		// the logger installed with ReplaceGlobal belongs to the caller
+		nl, err = buildLogger(config, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("build logger: %w", err)
+	}
+	storeGlobal(nl, true)
+	return nil
+}
+
+// GetConfig returns a copy of the config of the global logger, with the
+// level currently in effect. It returns nil when the global logger was
+// replaced by a Logger that isn't implemented by this package.
 func GetConfig() *Config {
-	return l.config
+	if l := current().l; l != nil {
+		return l.config.clone()
+	}
+	return nil
 }
 
 func SetLevel(level Level) {
-	l.SetLevel(level)
+	current().SetLevel(level)
 }
 
 func GetLevel() Level {
-	return l.Level()
+	return current().ext.Level()
 }
 
 func SetOutputPaths(outputPaths []string) {
-	l.config.OutputPaths = outputPaths
-	l = newLogger(l.config)
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	config := NewDefaultConfig()
+	if l := current().l; l != nil {
+		config = l.config.clone()
+	}
+	config.OutputPaths = outputPaths
+	if err := setConfigLocked(config); err != nil {
+		fmt.Fprintf(os.Stderr, "error on build zap logger (%s)", err)
+	}
 }
 
 func NewLogger(config *Config) Logger {
@@ -31,6 +171,86 @@ func NewLogger(config *Config) Logger {
 	return l
 }
 
+// NewLoggerE is like NewLogger but returns an error instead of a nil Logger
+// when config is invalid or the logger can't be built.
+func NewLoggerE(config *Config) (Logger, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	l, err := buildLogger(config, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build logger: %w", err)
+	}
+	return l, nil
+}
+
 func GetLogger() Logger {
-	return l
+	return current().Logger
+}
+
+func newNopLogger() *logger {
+	config := NewDefaultConfig()
+	config.buildZapConfig()
+	zapLogger := zap.NewNop()
+	return &logger{
+		logger:    zapLogger.Sugar(),
+		zapLogger: zapLogger,
+		config:    config,
+		core:      newCoreRef(zapcore.NewNopCore(), nil),
+	}
+}
+
+// foreignLogger adapts a Logger implemented outside this package for the
+// package level functions. DPanic logs at error level and Panic logs at
+// error level before panicking.
+type foreignLogger struct {
+	Logger
+}
+
+func (f foreignLogger) DPanic(args ...interface{}) {
+	f.Error(args...)
+}
+
+func (f foreignLogger) Panic(args ...interface{}) {
+	f.Error(args...)
+	panic(fmt.Sprint(args...))
+}
+
+func (f foreignLogger) DPanicf(template string, args ...interface{}) {
+	f.Errorf(template, args...)
+}
+
+func (f foreignLogger) Panicf(template string, args ...interface{}) {
+	f.Errorf(template, args...)
+	panic(fmt.Sprintf(template, args...))
+}
+
+func (f foreignLogger) DPanicw(msg string, keysAndValues ...interface{}) {
+	f.Errorw(msg, keysAndValues...)
+}
+
+func (f foreignLogger) Panicw(msg string, keysAndValues ...interface{}) {
+	f.Errorw(msg, keysAndValues...)
+	panic(msg)
+}
+
+func (f foreignLogger) Sync() error {
+	if s, ok := f.Logger.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (f foreignLogger) Level() Level {
+	if lv, ok := f.Logger.(interface{ Level() Level }); ok {
+		return lv.Level()
+	}
+	return InfoLevel
+}
+
+func (f foreignLogger) GetZapLogger() *zap.Logger {
+	if z, ok := f.Logger.(interface{ GetZapLogger() *zap.Logger }); ok {
+		return z.GetZapLogger()
+	}
+	return zap.NewNop()
 }
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordLogger struct {
+	Logger
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordLogger) record(args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, fmt.Sprint(args...))
+}
+
+func (r *recordLogger) Info(args ...interface{})  { r.record(args...) }
+func (r *recordLogger) Error(args ...interface{}) { r.record(args...) }
+
+// keepGlobal restores the global logger when the test ends. The test starts
+// with a discarding one, so that SetConfig builds a new logger instead of
+// reloading the kept one.
+func keepGlobal(t *testing.T) {
+	t.Cleanup(ReplaceGlobal(nil))
+}
+
+func TestReplaceGlobal(t *testing.T) {
+	keepGlobal(t)
+	SetConfig(NewProductionConfig())
+	before := GetLogger()
+
+	rec := &recordLogger{}
+	restore := ReplaceGlobal(rec)
+	Info("hello")
+	DPanic("oops")
+	if GetLogger() != rec || GetConfig() != nil || GetLevel() != InfoLevel {
+		t.Fatal("global logger not replaced")
+	}
+	if err := Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	restore()
+
+	if GetLogger() != before {
+		t.Fatal("global logger not restored")
+	}
+	if strings.Join(rec.lines, "|") != "hello|oops" {
+		t.Fatalf("recorded %q", rec.lines)
+	}
+
+	restore = ReplaceGlobal(nil)
+	Info("discarded")
+	With("key", "value").Info("discarded")
+	restore()
+}
+
+func TestSetConfigAfterReplaceGlobal(t *testing.T) {
+	keepGlobal(t)
+	dir := t.TempDir()
+	own, global := filepath.Join(dir, "own.log"), filepath.Join(dir, "global.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{own}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ReplaceGlobal(l)
+
+	config.OutputPaths = []string{global}
+	SetConfig(config)
+	l.Info("own logger")
+	Info("global logger")
+	if got := readLog(t, own); !strings.Contains(got, "own logger") || strings.Contains(got, "global logger") {
+		t.Fatalf("own.log = %q", got)
+	}
+	if got := readLog(t, global); !strings.Contains(got, "global logger") || strings.Contains(got, "own logger") {
+		t.Fatalf("global.log = %q", got)
+	}
+}
+
+func TestGlobalCaller(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{out}
+	keepGlobal(t)
+	SetConfig(config)
+
+	Info("package level")
+	With("key", "value").Info("derived")
+	for _, line := range strings.Split(strings.TrimSpace(readLog(t, out)), "\n") {
+		if !strings.Contains(line, "global_test.go") {
+			t.Fatalf("wrong caller in %s", line)
+		}
+	}
+}
+
+func TestGlobalConcurrentReplace(t *testing.T) {
+	config := NewProductionConfig()
+	config.OutputPaths = []string{filepath.Join(t.TempDir(), "out.log")}
+	keepGlobal(t)
+	SetConfig(config)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				Infow("background", "level", GetLevel())
+				With("key", "value").Ctx(context.Background()).Warn("derived")
+				_ = GetConfig()
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		SetLevel(WarnLevel)
+		SetOutputPaths(config.OutputPaths)
+		SetConfig(config)
+		restore := ReplaceGlobal(nil)
+		restore()
+	}
+	close(stop)
+	wg.Wait()
+}
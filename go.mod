@@ -3,9 +3,11 @@ module github.com/kk-kwok/logger
 go 1.20
 
 require (
+	github.com/klauspost/compress v1.16.7
 	go.opentelemetry.io/otel v1.8.0
 	go.opentelemetry.io/otel/trace v1.8.0
 	go.uber.org/zap v1.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// OutputStatus is the health of an output, see OutputStatuses.
+type OutputStatus struct {
+	// Output is the path of the output, or its URL without its query and
+	// password.
+	Output string
+
+	// Failures is the number of writes that failed, along with the failures
+	// reported on the Diagnostics channel.
+	Failures int64
+
+	// Fallbacks is the number of entries written to a fallback output
+	// instead, see Config.FallbackPaths.
+	Fallbacks int64
+
+	// Dropped is the number of entries lost.
+	Dropped int64
+
+	// LastError is the error of the last failure, at LastFailure.
+	LastError   error
+	LastFailure time.Time
+}
+
+var outputStatuses = struct {
+	sync.Mutex
+	m map[string]*OutputStatus
+}{m: make(map[string]*OutputStatus)}
+
+// OutputStatuses returns the status of the outputs opened so far, sorted by
+// output. The counts are kept across reloads.
+func OutputStatuses() []OutputStatus {
+	outputStatuses.Lock()
+	defer outputStatuses.Unlock()
+	statuses := make([]OutputStatus, 0, len(outputStatuses.m))
+	for _, s := range outputStatuses.m {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Output < statuses[j].Output })
+	return statuses
+}
+
+// CheckOutputs returns the last errors of the outputs that failed within the
+// last window, for health checks, and nil if none did.
+func CheckOutputs(window time.Duration) error {
+	var errs []error
+	since := time.Now().Add(-window)
+	for _, s := range OutputStatuses() {
+		if s.Failures > 0 && s.LastFailure.After(since) {
+			errs = append(errs, fmt.Errorf("output %s: %d failures: %w", s.Output, s.Failures, s.LastError))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// outputStatus returns the status of output, adding it if needed. The caller
+// must hold the lock.
+func outputStatus(output string) *OutputStatus {
+	s, ok := outputStatuses.m[output]
+	if !ok {
+		s = &OutputStatus{Output: output}
+		outputStatuses.m[output] = s
+	}
+	return s
+}
+
+// registerOutput lists output in OutputStatuses before it fails.
+func registerOutput(output string) {
+	outputStatuses.Lock()
+	outputStatus(output)
+	outputStatuses.Unlock()
+}
+
+func countOutputFailure(output string, err error, fallbacks, dropped int) {
+	outputStatuses.Lock()
+	defer outputStatuses.Unlock()
+	s := outputStatus(output)
+	s.Failures++
+	s.Fallbacks += int64(fallbacks)
+	s.Dropped += int64(dropped)
+	s.LastError, s.LastFailure = err, time.Now()
+}
+
+// statusName returns the name of the output at path in OutputStatuses, which
+// is the one of OutputError for URLs.
+func statusName(path string) string {
+	if u, err := url.Parse(path); err == nil && len(u.Scheme) > 1 {
+		return outputName(u)
+	}
+	return path
+}
+
+// fallbackChain counts the failed writes of an output, and writes their
+// entries to the first of its fallbacks that accepts them.
+type fallbackChain struct {
+	output string
+	outs   []zapcore.WriteSyncer
+}
+
+// failed handles the failure err to write p to the output. It returns nil if
+// a fallback wrote p.
+func (f *fallbackChain) failed(p []byte, err error) error {
+	if f.write(p) {
+		countOutputFailure(f.output, err, 1, 0)
+		return nil
+	}
+	countOutputFailure(f.output, err, 0, 1)
+	return err
+}
+
+// write writes p to the first fallback that accepts it, and returns false if
+// none did.
+func (f *fallbackChain) write(p []byte) bool {
+	for _, out := range f.outs {
+		if _, err := out.Write(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fallbackChain) sync() error {
+	var errs []error
+	for _, out := range f.outs {
+		errs = append(errs, out.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// fallbackSyncer is a zapcore.WriteSyncer with a fallbackChain.
+type fallbackSyncer struct {
+	zapcore.WriteSyncer
+	fallback *fallbackChain
+}
+
+func (w fallbackSyncer) Write(p []byte) (int, error) {
+	n, err := w.WriteSyncer.Write(p)
+	if err != nil {
+		if err = w.fallback.failed(p, err); err == nil {
+			n = len(p)
+		}
+	}
+	return n, err
+}
+
+func (w fallbackSyncer) Sync() error {
+	return errors.Join(w.WriteSyncer.Sync(), w.fallback.sync())
+}
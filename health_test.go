@@ -0,0 +1,190 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var errBrokenOutput = errors.New("broken output")
+
+// brokenSink fails every write.
+type brokenSink struct{}
+
+func (brokenSink) Write([]byte) (int, error) { return 0, errBrokenOutput }
+func (brokenSink) Sync() error               { return nil }
+func (brokenSink) Close() error              { return nil }
+
+// closedSinks are the URLs of the "closing" sinks closed so far.
+var closedSinks sync.Map
+
+// closingSink records that it's closed in closedSinks.
+type closingSink struct {
+	zapcore.WriteSyncer
+	url string
+}
+
+func (s closingSink) Close() error {
+	closedSinks.Store(s.url, true)
+	return nil
+}
+
+func init() {
+	_ = zap.RegisterSink("broken", func(*url.URL) (zap.Sink, error) { return brokenSink{}, nil })
+	_ = zap.RegisterSink("closing", func(u *url.URL) (zap.Sink, error) {
+		return closingSink{zapcore.AddSync(io.Discard), u.String()}, nil
+	})
+}
+
+// forgetOutputStatus resets the counts of output, for tests run repeatedly.
+func forgetOutputStatus(output string) {
+	outputStatuses.Lock()
+	delete(outputStatuses.m, output)
+	outputStatuses.Unlock()
+}
+
+func outputStatusOf(t *testing.T, output string) OutputStatus {
+	t.Helper()
+	for _, s := range OutputStatuses() {
+		if s.Output == output {
+			return s
+		}
+	}
+	t.Fatalf("no status for %s in %v", output, OutputStatuses())
+	return OutputStatus{}
+}
+
+func TestFallbackPaths(t *testing.T) {
+	forgetOutputStatus("broken://primary")
+	dir := t.TempDir()
+	fallback := filepath.Join(dir, "fallback.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{"broken://primary"}
+	config.FallbackPaths = []string{"broken://secondary", fallback}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := outputStatusOf(t, "broken://primary"); s.Failures != 0 {
+		t.Fatalf("status before writing = %+v", s)
+	}
+
+	l.Info("saved")
+	if data := readLog(t, fallback); !strings.Contains(data, `"msg":"saved"`) {
+		t.Fatalf("fallback = %q", data)
+	}
+	s := outputStatusOf(t, "broken://primary")
+	if s.Failures != 1 || s.Fallbacks != 1 || s.Dropped != 0 || !errors.Is(s.LastError, errBrokenOutput) {
+		t.Fatalf("status = %+v", s)
+	}
+	if err := CheckOutputs(time.Minute); err == nil || !strings.Contains(err.Error(), "broken://primary") {
+		t.Fatalf("CheckOutputs = %v", err)
+	}
+}
+
+func TestFallbackPathsBackground(t *testing.T) {
+	srv := newIngestServer(t, func(int) int { return http.StatusBadRequest })
+	forgetOutputStatus(srv.URL + "/ingest")
+	fallback := filepath.Join(t.TempDir(), "fallback.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{srv.URL + "/ingest?batchWait=1h"}
+	config.FallbackPaths = []string{fallback}
+	config.Sampling = nil
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Info("rejected")
+	// the batch is dropped when it's sent, after the entry was written
+	if err := syncLogger(l); err == nil {
+		t.Fatal("Sync() = nil")
+	}
+	if data := readLog(t, fallback); !strings.Contains(data, `"msg":"rejected"`) {
+		t.Fatalf("fallback = %q", data)
+	}
+	if s := outputStatusOf(t, srv.URL+"/ingest"); s.Failures != 1 || s.Fallbacks != 1 || s.Dropped != 0 {
+		t.Fatalf("status = %+v", s)
+	}
+}
+
+func TestErrorOutputPaths(t *testing.T) {
+	forgetOutputStatus("broken://output")
+	dir := t.TempDir()
+	errorLog := filepath.Join(dir, "errors.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{filepath.Join(dir, "app.log")}
+	config.Outputs = []OutputConfig{{Path: "broken://output", Fallback: []string{"broken://fallback"}}}
+	config.ErrorOutputPaths = []string{errorLog}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Info("lost")
+	if data := readLog(t, errorLog); !strings.Contains(data, "write error") || !strings.Contains(data, errBrokenOutput.Error()) {
+		t.Fatalf("error output = %q", data)
+	}
+	if s := outputStatusOf(t, "broken://output"); s.Failures != 1 || s.Dropped != 1 || s.Fallbacks != 0 {
+		t.Fatalf("status = %+v", s)
+	}
+}
+
+func TestOutputsClosedOnReload(t *testing.T) {
+	config := NewProductionConfig()
+	config.OutputPaths = []string{"closing://output"}
+	config.FallbackPaths = []string{"closing://fallback"}
+	config.ErrorOutputPaths = []string{"closing://errors"}
+	config.Outputs = []OutputConfig{{Path: "stdout", Fallback: []string{"closing://output-fallback"}}}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"closing://output", "closing://fallback", "closing://errors", "closing://output-fallback"} {
+		closedSinks.Delete(u)
+	}
+	if _, err := l.(*logger).reload(NewProductionConfig()); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"closing://output", "closing://fallback", "closing://errors", "closing://output-fallback"} {
+		if _, ok := closedSinks.Load(u); !ok {
+			t.Errorf("%s not closed", u)
+		}
+	}
+}
+
+func TestOutputStatusesDiagnostics(t *testing.T) {
+	forgetOutputStatus("http://ingest.test/logs")
+	before := time.Now()
+	reportOutputError(&OutputError{Output: "http://ingest.test/logs", Dropped: 3, Err: errBrokenOutput})
+	s := outputStatusOf(t, "http://ingest.test/logs")
+	if s.Failures != 1 || s.Dropped != 3 || s.LastFailure.Before(before) {
+		t.Fatalf("status = %+v", s)
+	}
+	// failures before the window are left out
+	if err := CheckOutputs(-time.Second); err != nil {
+		t.Fatalf("CheckOutputs = %v", err)
+	}
+}
+
+func TestValidateFallbackPaths(t *testing.T) {
+	missing := filepath.Join(os.TempDir(), "nonexistent-dir", "app.log")
+	config := NewProductionConfig()
+	config.FallbackPaths = []string{missing}
+	config.ErrorOutputPaths = []string{missing}
+	config.Outputs = []OutputConfig{{Path: "stdout", Fallback: []string{missing}}}
+	err := config.Validate()
+	if err == nil || strings.Count(err.Error(), "no such file") != 3 {
+		t.Fatalf("Validate = %v", err)
+	}
+}
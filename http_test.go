@@ -0,0 +1,324 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ingestServer records the requests sent to it.
+type ingestServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	bodies   []string
+	requests []*http.Request
+}
+
+func newIngestServer(t *testing.T, status func(n int) int) *ingestServer {
+	s := &ingestServer{}
+	var n int32
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = zr
+		}
+		data, _ := io.ReadAll(body)
+		code := status(int(atomic.AddInt32(&n, 1)))
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		// the client gave up on the requests canceled meanwhile
+		if code == http.StatusOK && r.Context().Err() == nil {
+			s.bodies = append(s.bodies, string(data))
+		}
+		s.mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *ingestServer) received() ([]string, []*http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...), append([]*http.Request(nil), s.requests...)
+}
+
+func statusOK(int) int { return http.StatusOK }
+
+// newOutputTestLogger returns an unsampled production logger writing to url,
+// with the config changed by opts.
+func newOutputTestLogger(t *testing.T, url string, opts ...func(*Config)) Logger {
+	t.Helper()
+	config := NewProductionConfig()
+	config.OutputPaths = []string{url}
+	config.Sampling = nil
+	for _, opt := range opts {
+		opt(config)
+	}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func syncLogger(l Logger) error {
+	return l.(interface{ Sync() error }).Sync()
+}
+
+func TestHTTPBatches(t *testing.T) {
+	s := newIngestServer(t, statusOK)
+	u := strings.Replace(s.URL, "http://", "http://user:pass@", 1)
+	l := newOutputTestLogger(t, u+"/ingest?batchSize=1KB&batchWait=1h&header=X-Env:%20test&team=a")
+	for i := 0; i < 50; i++ {
+		l.Infow("entry", "i", i)
+	}
+	if err := syncLogger(l); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies, requests := s.received()
+	if len(bodies) < 2 {
+		t.Fatalf("%d batches, want several", len(bodies))
+	}
+	entries := 0
+	for _, body := range bodies {
+		if len(body) > 1100 {
+			t.Errorf("batch of %d bytes", len(body))
+		}
+		entries += strings.Count(body, "\n")
+	}
+	if entries != 50 {
+		t.Fatalf("%d entries sent, want 50", entries)
+	}
+	r := requests[0]
+	if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+		t.Errorf("basic auth = %s:%s", user, pass)
+	}
+	if r.URL.Path != "/ingest" || r.URL.RawQuery != "team=a" {
+		t.Errorf("URL = %s", r.URL)
+	}
+	if r.Header.Get("X-Env") != "test" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("headers = %v", r.Header)
+	}
+}
+
+func TestHTTPBatchWait(t *testing.T) {
+	s := newIngestServer(t, statusOK)
+	l := newOutputTestLogger(t, s.URL+"?batchWait=10ms&compress=none&token=secret")
+	l.Info("waited")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if bodies, requests := s.received(); len(bodies) == 1 {
+			if requests[0].Header.Get("Authorization") != "Bearer secret" || requests[0].Header.Get("Content-Encoding") != "" {
+				t.Fatalf("headers = %v", requests[0].Header)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch not sent after batchWait")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	s := newIngestServer(t, func(n int) int {
+		if n < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	l := newOutputTestLogger(t, s.URL+"?backoff=1ms")
+	l.Info("retried")
+	if err := syncLogger(l); err != nil {
+		t.Fatal(err)
+	}
+	if bodies, requests := s.received(); len(bodies) != 1 || len(requests) != 3 {
+		t.Fatalf("%d batches in %d requests, want 1 in 3", len(bodies), len(requests))
+	}
+}
+
+func TestHTTPFailure(t *testing.T) {
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	s := newIngestServer(t, func(int) int { return http.StatusBadRequest })
+	l := newOutputTestLogger(t, s.URL+"/bad?backoff=1ms&token=secret")
+	l.Info("rejected")
+	var statusErr *httpStatusError
+	if err := syncLogger(l); !errors.As(err, &statusErr) || statusErr.status != http.StatusBadRequest {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if _, requests := s.received(); len(requests) != 1 {
+		t.Fatalf("%d requests, 4xx responses shouldn't be retried", len(requests))
+	}
+	select {
+	case e := <-Diagnostics():
+		if e.Output != s.URL+"/bad" || e.Dropped != 1 || strings.Contains(e.Error(), "secret") {
+			t.Fatalf("diagnostic = %v", e)
+		}
+	default:
+		t.Fatal("failure not reported")
+	}
+}
+
+// closeWithin closes s, failing if it takes longer than d.
+func closeWithin(t *testing.T, s interface{ Close() error }, d time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		_ = s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal("Close is blocked")
+	}
+}
+
+func TestHTTPCloseEndsRetries(t *testing.T) {
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	defer func(timeout time.Duration) { batcherCloseTimeout = timeout }(batcherCloseTimeout)
+	batcherCloseTimeout = 100 * time.Millisecond
+	s := newIngestServer(t, func(int) int { return http.StatusServiceUnavailable })
+	sink, err := openRecordSink(s.URL + "?backoff=1h&maxBackoff=1h&batchWait=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = sink.Write([]byte("{}\n"))
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if _, requests := s.received(); len(requests) > 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("no request")
+		}
+	}
+	closeWithin(t, sink, 5*time.Second)
+	if e := <-Diagnostics(); e.Dropped != 1 {
+		t.Fatalf("diagnostic = %v", e)
+	}
+}
+
+func TestHTTPCloseCancelsRequest(t *testing.T) {
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	defer func(timeout time.Duration) { batcherCloseTimeout = timeout }(batcherCloseTimeout)
+	batcherCloseTimeout = 100 * time.Millisecond
+	received := make(chan struct{}, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		received <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+	sink, err := openRecordSink(s.URL + "?batchWait=1ms&timeout=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = sink.Write([]byte("{}\n"))
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request")
+	}
+	closeWithin(t, sink, 2*time.Second)
+	if e := <-Diagnostics(); e.Dropped != 1 {
+		t.Fatalf("diagnostic = %v", e)
+	}
+	if len(received) != 1 {
+		t.Fatal("batch not sent again on close")
+	}
+}
+
+func TestHTTPCloseSendsInterruptedBatch(t *testing.T) {
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	received := make(chan struct{}, 1)
+	s := newIngestServer(t, func(n int) int {
+		if n == 1 {
+			// the first request is still in progress when the output closes
+			received <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+		}
+		return http.StatusOK
+	})
+	sink, err := openRecordSink(s.URL + "?batchWait=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = sink.Write([]byte("{}\n"))
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request")
+	}
+	closeWithin(t, sink, 2*time.Second)
+	if bodies, _ := s.received(); len(bodies) != 1 || len(diagnostics) > 0 {
+		t.Fatalf("%d batches sent, %d diagnostics", len(bodies), len(diagnostics))
+	}
+}
+
+func TestHTTPCloseTimeout(t *testing.T) {
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	defer func(timeout time.Duration) { batcherCloseTimeout = timeout }(batcherCloseTimeout)
+	batcherCloseTimeout = 100 * time.Millisecond
+	s := newIngestServer(t, func(int) int {
+		time.Sleep(40 * time.Millisecond)
+		return http.StatusOK
+	})
+	sink, err := openRecordSink(s.URL + "?batchSize=1KB&batchWait=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(`{"padding":"` + strings.Repeat("x", 500) + `"}` + "\n")
+	for i := 0; i < 20; i++ {
+		_, _ = sink.Write(line)
+	}
+	closeWithin(t, sink, 2*time.Second)
+
+	dropped, timedOut := 0, false
+	for len(diagnostics) > 0 {
+		e := <-diagnostics
+		dropped += e.Dropped
+		timedOut = timedOut || errors.Is(e, errCloseTimeout)
+	}
+	if bodies, _ := s.received(); !timedOut || dropped+strings.Count(strings.Join(bodies, ""), "\n") != 20 {
+		t.Fatalf("%d entries dropped, %d batches sent", dropped, len(bodies))
+	}
+}
+
+func TestHTTPOptions(t *testing.T) {
+	for _, u := range []string{
+		"http://localhost?batchSize=0",
+		"http://localhost?compress=zip",
+		"http://localhost?header=nocolon",
+		"http://localhost?retries=-1",
+	} {
+		if s, err := openRecordSink(u); err == nil {
+			_ = s.Close()
+			t.Errorf("%s: expected error", u)
+		}
+	}
+}
@@ -20,32 +20,71 @@ type logger struct {
 	fields    []interface{}
 	skipInit  bool
 	tracing   recordingType
+	core      *coreRef
 }
 
 func newLogger(config *Config) *logger {
-	config.buildZapConfig()
-
-	zapLogger, err := config.zapConfig.Build()
+	l, err := buildLogger(config, nil)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error on build zap logger (%s)", err)
 		return nil
 	}
-	zapLogger = zapLogger.WithOptions(zap.AddCallerSkip(config.CallerSkip))
+	return l
+}
+
+// buildLogger builds a root logger from config. When ref is not nil the new
+// zap core is stored into it, so every logger already derived from ref
+// switches over to it; the caller options of those loggers are kept.
+func buildLogger(config *Config, ref *coreRef) (*logger, error) {
+	// the logger keeps a private copy, so the caller's config can't change
+	// under loggers used concurrently
+	config = config.clone()
+	if err := config.buildZapConfig(); err != nil {
+		return nil, err
+	}
+
+	core, stop, err := config.buildCore()
+	if err != nil {
+		return nil, err
+	}
+	zapLogger := zap.New(config.Sampling.wrapCore(core), config.buildOptions()...)
+	if ref == nil {
+		ref = newCoreRef(zapLogger.Core(), stop)
+	} else {
+		ref.store(zapLogger.Core(), stop)
+	}
+	zapLogger = zapLogger.WithOptions(
+		zap.AddCallerSkip(config.CallerSkip),
+		zap.WrapCore(func(zapcore.Core) zapcore.Core { return newReloadableCore(ref) }),
+	)
 
 	return &logger{
 		logger:    zapLogger.Sugar(),
 		zapLogger: zapLogger,
 		config:    config,
+		core:      ref,
+	}, nil
+}
+
+// reload builds a root logger from config that also replaces the core of l
+// and of every logger derived from l. On error l is left untouched.
+func (l *logger) reload(config *Config) (*logger, error) {
+	var ref *coreRef
+	if l != nil {
+		ref = l.core
 	}
+	return buildLogger(config, ref)
 }
 
+// SetLevel changes the level atomically, for l and the loggers it was derived
+// from or derives. The config of l is left as it was built, Level reads the
+// level in effect.
 func (l *logger) SetLevel(level Level) {
-	l.config.Level = level
 	l.config.zapConfig.Level.SetLevel(zapcore.Level(level))
 }
 
 func (l *logger) Level() Level {
-	return l.config.Level
+	return Level(l.config.zapConfig.Level.Level())
 }
 
 func (l *logger) Debug(args ...interface{}) {
@@ -213,23 +252,37 @@ func (l *logger) WithCallerSkip(ctx context.Context, callerSkip int, tracing rec
 	copy(newFields, l.fields)
 	newFields = append(newFields, keyValues...)
 
-	sugar := l.logger.With(keyValues...)
+	// every entry of a logger with a span carries its context, for the
+	// outputs sending the ids of the span, such as OTLP
+	with := keyValues
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && !sc.Equal(trace.SpanContextFromContext(l.ctx)) {
+		with = append(with[:len(with):len(with)], spanContextField(sc))
+	}
+
+	sugar := l.logger.With(with...)
 	zaplogger := l.zapLogger
 
 	// only first With need skip caller, be aware DO NOT affect parent logger
 	if !l.skipInit && callerSkip != 0 {
-		zaplogger = l.logger.With(keyValues...).Desugar().WithOptions(zap.AddCallerSkip(callerSkip))
+		zaplogger = l.logger.With(with...).Desugar().WithOptions(zap.AddCallerSkip(callerSkip))
 		sugar = zaplogger.Sugar()
 	}
 
+	// the derived logger writes through the core and outputs of l, so it
+	// shares their runtime levels
+	config := l.config.clone()
+	config.zapConfig.Level = l.config.zapConfig.Level
+	config.outputLevels = l.config.outputLevels
+
 	newLogger := &logger{
 		ctx:       ctx,
 		fields:    newFields,
-		config:    l.config.clone(),
+		config:    config,
 		logger:    sugar,
 		zapLogger: zaplogger,
 		skipInit:  true,
 		tracing:   tracing,
+		core:      l.core,
 	}
 	return newLogger
 }
@@ -252,7 +305,7 @@ func (l *logger) tracingEvent(lvl zapcore.Level, msg string, keysAndValues ...in
 	}
 
 	// skip handling tracing if current logging level is not enabled
-	if !l.config.zapConfig.Level.Level().Enabled(lvl) {
+	if !l.zapLogger.Core().Enabled(lvl) {
 		return keysAndValues
 	}
 
@@ -0,0 +1,247 @@
+//go:build linux
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// JournaldScheme is the scheme of the journald output, which sends entries
+// to the systemd journal with its native protocol, on Linux only:
+//
+//	journald://
+//	journald:///run/systemd/journal/socket?app=api
+//
+// The path is the socket of journald, /run/systemd/journal/socket by default.
+// The app query parameter sets SYSLOG_IDENTIFIER, the name of the executable
+// by default.
+//
+// Every entry is sent with MESSAGE, PRIORITY (the syslog severity of the
+// level), SYSLOG_IDENTIFIER, CODE_FILE, CODE_LINE and CODE_FUNC from the
+// caller, LOGGER from the logger name and STACKTRACE when there's one. The
+// fields of the entry are sent upper-cased, with the characters journald
+// doesn't accept in field names replaced by underscores, and prefixed with
+// F_ when they'd collide with the fields above. Entries too large
+// for a datagram are passed to journald in a sealed memfd.
+const JournaldScheme = "journald"
+
+const defaultJournaldSocket = "/run/systemd/journal/socket"
+
+func init() {
+	registerRecordSink(JournaldScheme, newJournaldSink)
+}
+
+type journaldSink struct {
+	addr    *net.UnixAddr
+	appName string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournaldSink(u *url.URL) (recordSink, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("journald URL with a host: %s", u)
+	}
+	path := u.Path
+	if path == "" {
+		path = defaultJournaldSocket
+	}
+	s := &journaldSink{
+		addr:    &net.UnixAddr{Name: path, Net: "unixgram"},
+		appName: filepath.Base(os.Args[0]),
+	}
+	for key, values := range u.Query() {
+		if key != "app" {
+			return nil, fmt.Errorf("journald URL %s: %s: unknown parameter", u, key)
+		}
+		s.appName = values[len(values)-1]
+	}
+	return s, nil
+}
+
+// Write sends p as an InfoLevel message.
+func (s *journaldSink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: msg}, Line: p}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *journaldSink) writeRecord(r *record) error {
+	msg := s.format(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		// not connected, as file descriptors can only be passed with an
+		// address on datagram sockets
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	_, err := s.conn.WriteToUnix(msg, s.addr)
+	if errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS) {
+		return s.writeFile(msg)
+	}
+	return err
+}
+
+// writeFile passes msg in a file descriptor, for entries larger than the
+// datagrams can be.
+func (s *journaldSink) writeFile(msg []byte) error {
+	f, err := journalFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(msg); err != nil {
+		return err
+	}
+	// journald only accepts memfds that can't be changed anymore
+	const sealAll = 0x1 | 0x2 | 0x4 | 0x8 // F_SEAL_SEAL, SHRINK, GROW and WRITE
+	_, _, _ = syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), 1033 /* F_ADD_SEALS */, sealAll)
+	_, _, err = s.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), s.addr)
+	return err
+}
+
+// memfdCreate are the numbers of the memfd_create system call, which isn't
+// in the syscall package for every architecture.
+var memfdCreate = map[string]uintptr{
+	"386": 356, "amd64": 319, "arm": 385, "arm64": 279, "loong64": 279,
+	"mips": 4354, "mipsle": 4354, "mips64": 5314, "mips64le": 5314,
+	"ppc64": 360, "ppc64le": 360, "riscv64": 279, "s390x": 350,
+}
+
+// journalFile returns a memfd, or an unlinked file in /dev/shm on kernels
+// without memfds.
+func journalFile() (*os.File, error) {
+	if trap, ok := memfdCreate[runtime.GOARCH]; ok {
+		name := []byte("journal\x00")
+		const flags = 0x1 | 0x2 // MFD_CLOEXEC, MFD_ALLOW_SEALING
+		fd, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(&name[0])), flags, 0)
+		if errno == 0 {
+			return os.NewFile(fd, "journal"), nil
+		}
+	}
+	f, err := os.CreateTemp("/dev/shm", "journal.*")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *journaldSink) Sync() error {
+	return nil
+}
+
+func (s *journaldSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// format returns r in the native protocol of journald.
+func (s *journaldSink) format(r *record) []byte {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Message)
+	journalField(&b, "PRIORITY", strconv.Itoa(syslogSeverity(r.Level)))
+	journalField(&b, "SYSLOG_IDENTIFIER", s.appName)
+	if r.Caller.Defined {
+		journalField(&b, "CODE_FILE", r.Caller.File)
+		journalField(&b, "CODE_LINE", strconv.Itoa(r.Caller.Line))
+		if r.Caller.Function != "" {
+			journalField(&b, "CODE_FUNC", r.Caller.Function)
+		}
+	}
+	if r.LoggerName != "" {
+		journalField(&b, "LOGGER", r.LoggerName)
+	}
+	if r.Stack != "" {
+		journalField(&b, "STACKTRACE", r.Stack)
+	}
+
+	names := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		journalField(&b, journalFieldName(k), recordValue(r.Fields[k]))
+	}
+	return b.Bytes()
+}
+
+// journalField appends a field to b, with its size when value spans lines.
+func journalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if strings.IndexByte(value, '\n') < 0 {
+		b.WriteByte('=')
+	} else {
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+		b.WriteByte('\n')
+		b.Write(size[:])
+	}
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalReserved are the fields set by the sink, which entry fields can't
+// override.
+var journalReserved = map[string]bool{
+	"MESSAGE": true, "PRIORITY": true, "SYSLOG_IDENTIFIER": true,
+	"CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true,
+	"LOGGER": true, "STACKTRACE": true,
+}
+
+// journalFieldName returns k as a field name accepted by journald: upper case
+// letters, digits and underscores, not starting with an underscore or a
+// digit, at most 64 bytes. Names of journalReserved are prefixed with F_.
+func journalFieldName(k string) string {
+	name := []byte(strings.ToUpper(k))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	// fields starting with an underscore are reserved to journald
+	name = bytes.TrimLeft(name, "_")
+	if len(name) == 0 || name[0] <= '9' || journalReserved[string(name)] {
+		name = append([]byte("F_"), name...)
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return string(name)
+}
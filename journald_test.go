@@ -0,0 +1,122 @@
+//go:build linux
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// readJournal reads an entry sent to a journald stand-in, from a datagram or
+// from a file descriptor passed with it.
+func readJournal(t *testing.T, conn *net.UnixConn) map[string]string {
+	t.Helper()
+	buf, oob := make([]byte, 1<<16), make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf[:n]
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := os.NewFile(uintptr(fds[0]), "journal")
+		defer f.Close()
+		if data, err = io.ReadAll(io.NewSectionReader(f, 0, 1<<30)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "=\n")
+		if i < 0 {
+			t.Fatalf("bad field %q", data)
+		}
+		name := string(data[:i])
+		if data[i] == '=' {
+			end := bytes.IndexByte(data, '\n')
+			fields[name] = string(data[i+1 : end])
+			data = data[end+1:]
+			continue
+		}
+		size := int(binary.LittleEndian.Uint64(data[i+1 : i+9]))
+		fields[name] = string(data[i+9 : i+9+size])
+		data = data[i+10+size:]
+	}
+	return fields
+}
+
+func TestJournald(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	config := NewProductionConfig()
+	config.OutputPaths = []string{"journald://" + path + "?app=api"}
+	config.Sampling = nil
+	config.CallerSkip = 1
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Warnw("disk full", "disk", "sda", "free-space", 3, "_private", true, "note", "two\nlines", "message", "raw")
+
+	got := readJournal(t, conn)
+	want := map[string]string{
+		"MESSAGE": "disk full", "PRIORITY": "4", "SYSLOG_IDENTIFIER": "api",
+		"DISK": "sda", "FREE_SPACE": "3", "PRIVATE": "true", "NOTE": "two\nlines",
+		"F_MESSAGE": "raw",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if !strings.HasSuffix(got["CODE_FILE"], "journald_test.go") || got["CODE_LINE"] == "" {
+		t.Errorf("caller = %s:%s", got["CODE_FILE"], got["CODE_LINE"])
+	}
+
+	// too large for a datagram
+	large := strings.Repeat("x", 4<<20)
+	l.Infow("large", "payload", large)
+	if got := readJournal(t, conn); got["MESSAGE"] != "large" || got["PAYLOAD"] != large {
+		t.Fatalf("large entry: MESSAGE = %q, %d bytes of PAYLOAD", got["MESSAGE"], len(got["PAYLOAD"]))
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for k, want := range map[string]string{
+		"user.id":  "USER_ID",
+		"__x":      "X",
+		"1st":      "F_1ST",
+		"":         "F_",
+		"héllo":    "H__LLO",
+		"trace_id": "TRACE_ID",
+		"message":  "F_MESSAGE",
+		"priority": "F_PRIORITY",
+		"_logger":  "F_LOGGER",
+	} {
+		if got := journalFieldName(k); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", k, got, want)
+		}
+	}
+}
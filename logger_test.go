@@ -3,6 +3,7 @@ package logger
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"go.uber.org/zap"
@@ -222,7 +223,7 @@ func TestConfigClone(t *testing.T) {
 	cloned.OutputPaths = append(cloned.OutputPaths, "/dev/stdout")
 	cloned.InitialFields["hello"] = "world"
 	cloned.zapConfig.Level = zap.NewAtomicLevelAt(zap.FatalLevel)
-	cloned.zapConfig.Sampling.Thereafter = 300
+	cloned.Sampling.Thereafter = 300
 
 	if config.EnableColor == cloned.EnableColor {
 		t.Fatalf("cloned.EnableColor fail")
@@ -236,7 +237,99 @@ func TestConfigClone(t *testing.T) {
 	if config.zapConfig.Level == cloned.zapConfig.Level {
 		t.Fatalf("cloned.zapConfig.Level fail")
 	}
-	if config.zapConfig.Sampling.Thereafter == cloned.zapConfig.Sampling.Thereafter {
+	if config.Sampling.Thereafter == cloned.Sampling.Thereafter {
 		t.Fatalf("cloned.Sampling.Thereafter fail")
 	}
 }
+
+func TestConfigValidate(t *testing.T) {
+	if err := NewProductionConfig(FieldPair{"service", "api"}).Validate(); err != nil {
+		t.Fatalf("production config: %v", err)
+	}
+
+	config := NewProductionConfig(FieldPair{"service"}, FieldPair{"a", "b", "c"})
+	if config.InitialFields["a"] != "b" {
+		t.Errorf("InitialFields = %v, want the malformed pair kept", config.InitialFields)
+	}
+	config.Encoding = "xml"
+	config.Level = Level(42)
+	config.OutputPaths = []string{"stderr", "", "/no/such/dir/app.log"}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"xml", "42", "empty output path", "/no/such/dir", `["service"]`, `["a" "b" "c"]`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %s: %v", want, err)
+		}
+	}
+}
+
+func TestNewLoggerE(t *testing.T) {
+	config := NewProductionConfig()
+	config.OutputPaths = []string{"unknown-scheme://somewhere"}
+	if l, err := NewLoggerE(config); err == nil || l != nil {
+		t.Fatalf("NewLoggerE = %v, %v, want error", l, err)
+	}
+
+	l, err := NewLoggerE(NewProductionConfig())
+	if err != nil || l == nil {
+		t.Fatalf("NewLoggerE = %v, %v", l, err)
+	}
+}
+
+func TestSetConfigE(t *testing.T) {
+	SetConfig(NewProductionConfig())
+	before := GetLogger()
+
+	config := NewProductionConfig()
+	config.Encoding = "xml"
+	if err := SetConfigE(config); err == nil {
+		t.Fatal("expected error")
+	}
+	SetConfig(config)
+	if GetLogger() != before {
+		t.Fatal("invalid config replaced the global logger")
+	}
+	Info("global logger still works")
+}
+
+type legacyConfig struct{}
+
+func (legacyConfig) GetLevel() string           { return "warn" }
+func (legacyConfig) GetOutput() string          { return "stdout" }
+func (legacyConfig) GetEncoding() string        { return "console" }
+func (legacyConfig) GetDisableStacktrace() bool { return true }
+func (legacyConfig) GetInitialFields() map[string]interface{} {
+	return map[string]interface{}{"k": "v"}
+}
+
+type frameworkConfig struct {
+	legacyConfig
+}
+
+func (frameworkConfig) GetOutputPaths() []string     { return []string{"stdout", "app.log"} }
+func (frameworkConfig) GetEnableColor() bool         { return true }
+func (frameworkConfig) GetDisableCaller() bool       { return true }
+func (frameworkConfig) GetCallerSkip() int           { return 3 }
+func (frameworkConfig) GetSampling() *SamplingConfig { return nil }
+func (frameworkConfig) GetEncoder() EncoderConfig    { return EncoderConfig{MessageKey: "message"} }
+
+func TestNewConfigFromInterface(t *testing.T) {
+	config := NewConfigFromInterface(legacyConfig{})
+	if config.Level != WarnLevel || len(config.OutputPaths) != 1 || config.Sampling == nil || config.EnableColor {
+		t.Fatalf("legacy config = %+v", config)
+	}
+
+	config = NewConfigFromInterface(frameworkConfig{})
+	if len(config.OutputPaths) != 2 || !config.EnableColor || !config.DisableCaller || config.CallerSkip != 3 ||
+		config.Sampling != nil || config.Encoder.MessageKey != "message" || config.ShortTime {
+		t.Fatalf("framework config = %+v", config)
+	}
+
+	dev := NewDevelopmentConfig()
+	dev.OutputPaths = []string{"stdout", "stderr"}
+	if diff := dev.Snapshot().Diff(NewConfigFromInterface(dev).Snapshot()); len(diff) != 0 {
+		t.Fatalf("config round trip differs: %+v", diff)
+	}
+}
@@ -0,0 +1,179 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The loki outputs push entries to Grafana Loki, loki over HTTP and
+// loki+https over HTTPS:
+//
+//	loki://loki:3100?labels=service,env,level&tenant=team-a
+//
+// The entries are pushed to /loki/api/v1/push, or to the path of the URL if
+// it has one. The query parameters are the ones of the http outputs, apart
+// from contentType, and:
+//
+//   - labels: the keys of the fields promoted to stream labels, separated by
+//     commas, "level" by default. The level key is the level of the entry,
+//     the other keys are fields of the entry or of the logger, such as
+//     InitialFields. Entries without any of them get the level label.
+//   - tenant: the tenant, sent as the X-Scope-OrgID header
+//
+// Promoted fields are left out of the lines, which are encoded with the
+// encoder of the output. Since every distinct set of label values is a
+// stream of its own, only the listed keys are promoted, and keys that aren't
+// valid label names are refused.
+const (
+	LokiScheme      = "loki"
+	LokiHTTPSScheme = "loki+https"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func init() {
+	registerRecordSink(LokiScheme, newLokiSink)
+	registerRecordSink(LokiHTTPSScheme, newLokiSink)
+}
+
+type lokiSink struct {
+	*batcher
+	poster   *poster
+	endpoint string
+	labels   map[string]bool
+	enc      zapcore.Encoder
+}
+
+func newLokiSink(u *url.URL) (recordSink, error) {
+	o, endpoint, err := parseHTTPOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	o.header.Set("Content-Type", "application/json")
+	s := &lokiSink{labels: map[string]bool{"level": true}}
+	for key, values := range endpoint.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "labels":
+			s.labels = make(map[string]bool)
+			for _, label := range strings.Split(value, ",") {
+				if label = strings.TrimSpace(label); !lokiLabelName.MatchString(label) {
+					return nil, fmt.Errorf("output %s: labels: %q is not a valid label name", outputName(u), label)
+				}
+				s.labels[label] = true
+			}
+		case "tenant":
+			o.header.Set("X-Scope-OrgID", value)
+		default:
+			return nil, fmt.Errorf("output %s: %s: unknown parameter", outputName(u), key)
+		}
+	}
+	endpoint.Scheme = "http"
+	if u.Scheme == LokiHTTPSScheme {
+		endpoint.Scheme = "https"
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = lokiPushPath
+	}
+	endpoint.RawQuery = ""
+	s.poster, s.endpoint = newPoster(o), endpoint.String()
+	s.batcher = newBatcher(int(o.batchSize), o.batchWait, outputName(u), s.send)
+	return s, nil
+}
+
+func (s *lokiSink) setEncoder(encoding string, config zapcore.EncoderConfig) {
+	s.enc = newEncoder(encoding, config)
+}
+
+// Write sends p as an InfoLevel entry.
+func (s *lokiSink) Write(p []byte) (int, error) {
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now()}, Line: p}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *lokiSink) writeRecord(r *record) error {
+	line := r.Line
+	if s.enc != nil {
+		buf, err := encodeRecord(s.enc, r, func(k string) bool { return s.labels[k] })
+		if err != nil {
+			return err
+		}
+		defer buf.Free()
+		line = buf.Bytes()
+	}
+	r.Line = append([]byte(nil), line...)
+	s.add(r)
+	return nil
+}
+
+// streamLabels returns the labels of the stream of r.
+func (s *lokiSink) streamLabels(r *record) map[string]string {
+	labels := make(map[string]string)
+	for k := range s.labels {
+		if k == "level" {
+			labels[k] = r.Level.String()
+		} else if v, ok := r.Fields[k]; ok {
+			labels[k] = recordValue(v)
+		}
+	}
+	if len(labels) == 0 {
+		labels["level"] = r.Level.String()
+	}
+	return labels
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) send(ctx context.Context, batch []*record) error {
+	var streams []*lokiStream
+	byKey := make(map[string]*lokiStream)
+	for _, r := range batch {
+		labels := s.streamLabels(r)
+		key := lokiStreamKey(labels)
+		stream, ok := byKey[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			byKey[key] = stream
+			streams = append(streams, stream)
+		}
+		line := strings.TrimSuffix(string(r.Line), "\n")
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(r.Time.UnixNano(), 10), line})
+	}
+	body, err := json.Marshal(map[string]interface{}{"streams": streams})
+	if err != nil {
+		return fmt.Errorf("loki: %w", err)
+	}
+	_, err = s.poster.post(ctx, s.endpoint, body, nil)
+	return err
+}
+
+// lokiStreamKey returns labels in the form of a stream selector.
+func lokiStreamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,455 @@
+package logger
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The otlp outputs export entries as OpenTelemetry log records to a
+// collector, with OTLP/HTTP or gRPC and protobuf messages:
+//
+//	otlp://collector:4318?resource=deployment.environment%3Dprod
+//	otlp+https://collector:4318/v1/logs
+//	otlp+grpc://collector:4317
+//	otlp+grpcs://collector:4317
+//
+// otlp and otlp+https post to /v1/logs, or to the path of the URL if it has
+// one. otlp+grpc and otlp+grpcs call the Export method of the logs service,
+// otlp+grpc without TLS, which needs Go 1.24 or later. The query parameters
+// are the ones of the http outputs, apart from contentType, and:
+//
+//   - resource: resource attributes as "key=value" pairs separated by
+//     commas, added to the ones of OTEL_RESOURCE_ATTRIBUTES and
+//     OTEL_SERVICE_NAME. service.name is the name of the executable by
+//     default.
+//
+// Every entry is a log record with the severity number and text of the
+// level, the message as the body, the fields of the entry and of the logger
+// as attributes along with code.filepath, code.lineno and code.function from
+// the caller, and, for entries logged with Ctx or WithTraceID, the trace id,
+// span id and trace flags of the span of the context.
+const (
+	OTLPScheme      = "otlp"
+	OTLPHTTPSScheme = "otlp+https"
+	OTLPGRPCScheme  = "otlp+grpc"
+	OTLPGRPCSScheme = "otlp+grpcs"
+)
+
+const (
+	otlpLogsPath   = "/v1/logs"
+	otlpExportPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+	otlpScopeName  = "github.com/kk-kwok/logger"
+)
+
+func init() {
+	for _, scheme := range []string{OTLPScheme, OTLPHTTPSScheme, OTLPGRPCScheme, OTLPGRPCSScheme} {
+		registerRecordSink(scheme, newOTLPSink)
+	}
+}
+
+type otlpSink struct {
+	*batcher
+	poster   *poster
+	endpoint string
+	resource []byte // encoded Resource
+}
+
+func newOTLPSink(u *url.URL) (recordSink, error) {
+	o, endpoint, err := parseHTTPOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	o.header.Set("Content-Type", "application/x-protobuf")
+	resource := otlpResource()
+	for key, values := range endpoint.Query() {
+		if key != "resource" {
+			return nil, fmt.Errorf("output %s: %s: unknown parameter", outputName(u), key)
+		}
+		if err := parseResourceAttributes(resource, values[len(values)-1]); err != nil {
+			return nil, fmt.Errorf("output %s: resource: %w", outputName(u), err)
+		}
+	}
+
+	p := newPoster(o)
+	endpoint.RawQuery = ""
+	switch u.Scheme {
+	case OTLPScheme, OTLPHTTPSScheme:
+		endpoint.Scheme = "http"
+		if u.Scheme == OTLPHTTPSScheme {
+			endpoint.Scheme = "https"
+		}
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = otlpLogsPath
+		}
+	default:
+		endpoint.Scheme, endpoint.Path, p.grpc = "https", otlpExportPath, true
+		if u.Scheme == OTLPGRPCScheme {
+			if p.client.Transport, err = h2cTransport(); err != nil {
+				return nil, fmt.Errorf("output %s: %w", outputName(u), err)
+			}
+			endpoint.Scheme = "http"
+		}
+	}
+
+	s := &otlpSink{poster: p, endpoint: endpoint.String(), resource: otlpResourceMessage(resource)}
+	s.batcher = newBatcher(int(o.batchSize), o.batchWait, outputName(u), s.send)
+	return s, nil
+}
+
+// otlpResource returns the resource attributes of the environment.
+func otlpResource() map[string]string {
+	resource := map[string]string{"service.name": filepath.Base(os.Args[0])}
+	// invalid attributes of the environment are ignored, as by the SDKs
+	_ = parseResourceAttributes(resource, os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		resource["service.name"] = name
+	}
+	return resource
+}
+
+// parseResourceAttributes adds the "key=value" pairs of s to resource.
+func parseResourceAttributes(resource map[string]string, s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		resource[strings.TrimSpace(k)] = value
+	}
+	return nil
+}
+
+// Write sends p as an InfoLevel entry.
+func (s *otlpSink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: msg}}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeRecord queues r with its Line replaced by the LogRecord message.
+func (s *otlpSink) writeRecord(r *record) error {
+	r.Line = otlpLogRecord(r)
+	s.add(r)
+	return nil
+}
+
+func (s *otlpSink) send(ctx context.Context, batch []*record) error {
+	var scope []byte
+	scope = protoBytes(scope, 1, protoString(nil, 1, otlpScopeName))
+	for _, r := range batch {
+		scope = protoBytes(scope, 2, r.Line)
+	}
+	var resourceLogs []byte
+	resourceLogs = protoBytes(resourceLogs, 1, s.resource)
+	resourceLogs = protoBytes(resourceLogs, 2, scope)
+	_, err := s.poster.post(ctx, s.endpoint, protoBytes(nil, 1, resourceLogs), nil)
+	return err
+}
+
+// otlpSeverity returns the severity number of lvl.
+func otlpSeverity(lvl zapcore.Level) uint64 {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return 5
+	case zapcore.InfoLevel:
+		return 9
+	case zapcore.WarnLevel:
+		return 13
+	case zapcore.ErrorLevel:
+		return 17
+	case zapcore.DPanicLevel:
+		return 19
+	case zapcore.PanicLevel:
+		return 21
+	}
+	return 24
+}
+
+// The messages below are the ones of opentelemetry/proto/logs/v1/logs.proto
+// and opentelemetry/proto/common/v1/common.proto, encoded by hand to keep
+// protobuf and gRPC out of the dependencies.
+
+func otlpResourceMessage(attributes map[string]string) []byte {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b []byte
+	for _, k := range keys {
+		b = protoBytes(b, 1, otlpKeyValue(k, attributes[k]))
+	}
+	return b
+}
+
+func otlpLogRecord(r *record) []byte {
+	var b []byte
+	b = protoFixed64(b, 1, uint64(r.Time.UnixNano()))
+	b = protoVarint(b, 2, otlpSeverity(r.Level))
+	b = protoString(b, 3, r.Level.CapitalString())
+	b = protoBytes(b, 5, otlpAnyValue(r.Message))
+
+	keys := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = protoBytes(b, 6, otlpKeyValue(k, r.Fields[k]))
+	}
+	if r.Caller.Defined {
+		b = protoBytes(b, 6, otlpKeyValue("code.filepath", r.Caller.File))
+		b = protoBytes(b, 6, otlpKeyValue("code.lineno", r.Caller.Line))
+		if r.Caller.Function != "" {
+			b = protoBytes(b, 6, otlpKeyValue("code.function", r.Caller.Function))
+		}
+	}
+	if r.LoggerName != "" {
+		b = protoBytes(b, 6, otlpKeyValue("logger.name", r.LoggerName))
+	}
+
+	if sc := r.SpanContext; sc.IsValid() {
+		traceID, spanID := sc.TraceID(), sc.SpanID()
+		b = protoFixed32(b, 8, uint32(sc.TraceFlags()))
+		b = protoBytes(b, 9, traceID[:])
+		b = protoBytes(b, 10, spanID[:])
+	}
+	return protoFixed64(b, 11, uint64(time.Now().UnixNano()))
+}
+
+func otlpKeyValue(k string, v interface{}) []byte {
+	return protoBytes(protoString(nil, 1, k), 2, otlpAnyValue(v))
+}
+
+// otlpAnyValue returns an AnyValue for a value decoded by a
+// zapcore.MapObjectEncoder.
+func otlpAnyValue(v interface{}) []byte {
+	var b []byte
+	switch v := v.(type) {
+	case string:
+		return protoString(b, 1, v)
+	case bool:
+		if v {
+			return protoVarint(b, 2, 1)
+		}
+		return protoVarint(b, 2, 0)
+	case int:
+		return protoVarint(b, 3, uint64(v))
+	case int8:
+		return protoVarint(b, 3, uint64(v))
+	case int16:
+		return protoVarint(b, 3, uint64(v))
+	case int32:
+		return protoVarint(b, 3, uint64(v))
+	case int64:
+		return protoVarint(b, 3, uint64(v))
+	case uint:
+		return protoVarint(b, 3, uint64(v))
+	case uint8:
+		return protoVarint(b, 3, uint64(v))
+	case uint16:
+		return protoVarint(b, 3, uint64(v))
+	case uint32:
+		return protoVarint(b, 3, uint64(v))
+	case uint64:
+		return protoVarint(b, 3, v)
+	case uintptr:
+		return protoVarint(b, 3, uint64(v))
+	case float32:
+		return protoFixed64(b, 4, math.Float64bits(float64(v)))
+	case float64:
+		return protoFixed64(b, 4, math.Float64bits(v))
+	case []interface{}:
+		var values []byte
+		for _, e := range v {
+			values = protoBytes(values, 1, otlpAnyValue(e))
+		}
+		return protoBytes(b, 5, values)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var values []byte
+		for _, k := range keys {
+			values = protoBytes(values, 1, otlpKeyValue(k, v[k]))
+		}
+		return protoBytes(b, 6, values)
+	case []byte:
+		return protoBytes(b, 7, v)
+	case time.Time:
+		return protoString(b, 1, v.Format(time.RFC3339Nano))
+	case time.Duration:
+		return protoString(b, 1, v.String())
+	}
+	return protoString(b, 1, recordValue(v))
+}
+
+// Protobuf encoding.
+
+func protoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func protoVarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(protoTag(b, field, 0), v)
+}
+
+func protoFixed64(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(protoTag(b, field, 1), v)
+}
+
+func protoFixed32(b []byte, field int, v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(protoTag(b, field, 5), v)
+}
+
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(protoTag(b, field, 2), uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoString(b []byte, field int, s string) []byte {
+	b = binary.AppendUvarint(protoTag(b, field, 2), uint64(len(s)))
+	return append(b, s...)
+}
+
+// protoEach calls f with the length-delimited fields of the message b, and
+// skips the other fields. It stops at the first malformed field.
+func protoEach(b []byte, f func(field int, v []byte)) {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return
+			}
+		case 1:
+			n = 8
+		case 2:
+			size, m := binary.Uvarint(b)
+			if m <= 0 || size > uint64(len(b)-m) {
+				return
+			}
+			f(int(tag>>3), b[m:m+int(size)])
+			n = m + int(size)
+		case 5:
+			n = 4
+		default:
+			return
+		}
+		if n > len(b) {
+			return
+		}
+		b = b[n:]
+	}
+}
+
+// gRPC over HTTP/2.
+
+// grpcFrame returns msg as the message of a gRPC request.
+func grpcFrame(msg []byte, compressed bool) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	if compressed {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// grpcStatusError is a gRPC call that failed.
+type grpcStatusError struct {
+	code    int
+	message string
+
+	// retryInfo is set when the details of the status hold a RetryInfo.
+	retryInfo bool
+}
+
+func (e *grpcStatusError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.message)
+}
+
+// retryable reports whether the call may succeed later, as listed by the
+// OTLP specification.
+func (e *grpcStatusError) retryable() bool {
+	switch e.code {
+	case 1, 4, 10, 11, 14, 15: // CANCELLED, DEADLINE_EXCEEDED, ABORTED, OUT_OF_RANGE, UNAVAILABLE, DATA_LOSS
+		return true
+	case 8: // RESOURCE_EXHAUSTED, only when the collector may recover
+		return e.retryInfo
+	}
+	return false
+}
+
+// grpcStatus returns the status of a gRPC response, whose body has been read,
+// when it isn't OK.
+func grpcStatus(resp *http.Response) *grpcStatusError {
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		// responses without a message have the status in their headers
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status == "0" {
+		return nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return &grpcStatusError{code: 2, message: fmt.Sprintf("invalid status %q", status)} // UNKNOWN
+	}
+	message, _ = url.PathUnescape(message)
+	details := resp.Trailer.Get("Grpc-Status-Details-Bin")
+	if details == "" {
+		details = resp.Header.Get("Grpc-Status-Details-Bin")
+	}
+	return &grpcStatusError{code: code, message: message, retryInfo: grpcRetryInfo(details)}
+}
+
+// grpcRetryInfo reports whether details, the google.rpc.Status of a response
+// in base64, has a google.rpc.RetryInfo among its details.
+func grpcRetryInfo(details string) bool {
+	// the padding is optional
+	status, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(details, "="))
+	if err != nil {
+		return false
+	}
+	found := false
+	protoEach(status, func(field int, detail []byte) {
+		if field != 3 {
+			return
+		}
+		// a google.protobuf.Any
+		protoEach(detail, func(field int, v []byte) {
+			found = found || field == 1 && string(v) == "type.googleapis.com/google.rpc.RetryInfo"
+		})
+	})
+	return found
+}
@@ -0,0 +1,14 @@
+//go:build go1.24
+
+package logger
+
+import "net/http"
+
+// h2cTransport returns a transport sending HTTP/2 requests without TLS, for
+// gRPC.
+func h2cTransport() (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return t, nil
+}
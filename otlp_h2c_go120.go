@@ -0,0 +1,14 @@
+//go:build !go1.24
+
+package logger
+
+import (
+	"errors"
+	"net/http"
+)
+
+// h2cTransport would return a transport sending HTTP/2 requests without TLS,
+// which net/http only supports from Go 1.24.
+func h2cTransport() (http.RoundTripper, error) {
+	return nil, errors.New("gRPC without TLS needs Go 1.24 or later, use otlp+grpcs or otlp")
+}
@@ -0,0 +1,30 @@
+//go:build go1.24
+
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOTLPGRPCWithoutTLS(t *testing.T) {
+	collector := &fakeCollector{t: t}
+	srv := httptest.NewUnstartedServer(collector)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	l := newOutputTestLogger(t, strings.Replace(srv.URL, "http", "otlp+grpc", 1)+"?compress=none")
+	l.Errorw("failed", "attempt", 3)
+	if err := syncLogger(l); err != nil {
+		t.Fatal(err)
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.records) != 1 || collector.records[0][2][0].u != 17 {
+		t.Fatalf("records = %v", collector.records)
+	}
+}
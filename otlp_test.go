@@ -0,0 +1,290 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// protoMessage is a decoded protobuf message: the values of its fields by
+// number, varints and fixed values as u, length-delimited ones as b.
+type protoMessage map[int][]protoValue
+
+type protoValue struct {
+	u uint64
+	b []byte
+}
+
+func decodeProto(t *testing.T, data []byte) protoMessage {
+	t.Helper()
+	m := make(protoMessage)
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		data = data[n:]
+		var v protoValue
+		switch tag & 7 {
+		case 0:
+			v.u, n = binary.Uvarint(data)
+			data = data[n:]
+		case 1:
+			v.u, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			v.b, data = data[n:n+int(size)], data[n+int(size):]
+		case 5:
+			v.u, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			t.Fatalf("wire type %d", tag&7)
+		}
+		m[int(tag>>3)] = append(m[int(tag>>3)], v)
+	}
+	return m
+}
+
+func (m protoMessage) message(t *testing.T, field int) protoMessage {
+	return decodeProto(t, m[field][0].b)
+}
+
+// attributes returns the KeyValues of field as strings, ints or floats.
+func (m protoMessage) attributes(t *testing.T, field int) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for _, kv := range m[field] {
+		kv := decodeProto(t, kv.b)
+		value := kv.message(t, 2)
+		switch {
+		case value[1] != nil:
+			attrs[string(kv[1][0].b)] = string(value[1][0].b)
+		case value[3] != nil:
+			attrs[string(kv[1][0].b)] = int64(value[3][0].u)
+		case value[4] != nil:
+			attrs[string(kv[1][0].b)] = math.Float64frombits(value[4][0].u)
+		default:
+			attrs[string(kv[1][0].b)] = value
+		}
+	}
+	return attrs
+}
+
+// fakeCollector records the log records exported to it with OTLP/HTTP or
+// gRPC, failing the first ones with failures.
+type fakeCollector struct {
+	t        *testing.T
+	failures int
+
+	mu        sync.Mutex
+	calls     int
+	resources []protoMessage
+	records   []protoMessage
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	grpc := r.Header.Get("Content-Type") == "application/grpc"
+	if grpc {
+		compressed := body[0] == 1
+		body = body[5:]
+		if compressed {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				c.t.Error(err)
+				return
+			}
+			body, _ = io.ReadAll(zr)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	} else if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			c.t.Error(err)
+			return
+		}
+		body, _ = io.ReadAll(zr)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.calls <= c.failures {
+		if grpc {
+			w.Header().Set("Grpc-Status", "14")
+			w.Header().Set("Grpc-Message", "unavailable")
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+	req := decodeProto(c.t, body)
+	for _, rl := range req[1] {
+		resourceLogs := decodeProto(c.t, rl.b)
+		c.resources = append(c.resources, resourceLogs.message(c.t, 1))
+		for _, sl := range resourceLogs[2] {
+			for _, lr := range decodeProto(c.t, sl.b)[2] {
+				c.records = append(c.records, decodeProto(c.t, lr.b))
+			}
+		}
+	}
+	if grpc {
+		_, _ = w.Write(grpcFrame(nil, false))
+		w.Header().Set("Grpc-Status", "0")
+	}
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	collector := &fakeCollector{t: t, failures: 1}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	l := newOutputTestLogger(t, strings.Replace(srv.URL, "http", "otlp", 1)+"?backoff=1ms&resource=deployment.environment%3Dprod")
+	traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	spanID := trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+	l.With("user", "bob").Ctx(ctx).Warnw("slow request", "ms", 250, "ratio", 0.5)
+	l.WithTraceID(ctx).Infof("done in %dms", 250)
+	l.Info("plain")
+	if err := syncLogger(l); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.calls != 2 || len(collector.records) != 3 {
+		t.Fatalf("%d records in %d calls, want 3 in 2", len(collector.records), collector.calls)
+	}
+	resource := collector.resources[0].attributes(t, 1)
+	if resource["deployment.environment"] != "prod" || resource["service.name"] == "" {
+		t.Fatalf("resource = %v", resource)
+	}
+
+	r := collector.records[0]
+	if r[2][0].u != 13 || string(r[3][0].b) != "WARN" || string(r.message(t, 5)[1][0].b) != "slow request" {
+		t.Fatalf("severity %d %s, body %q", r[2][0].u, r[3][0].b, r[5][0].b)
+	}
+	attrs := r.attributes(t, 6)
+	if attrs["user"] != "bob" || attrs["ms"] != int64(250) || attrs["ratio"] != 0.5 ||
+		!strings.HasSuffix(attrs["code.filepath"].(string), "otlp_test.go") {
+		t.Fatalf("attributes = %v", attrs)
+	}
+	if !bytes.Equal(r[9][0].b, traceID[:]) || !bytes.Equal(r[10][0].b, spanID[:]) || r[8][0].u != 1 {
+		t.Fatalf("trace %x span %x flags %d", r[9][0].b, r[10][0].b, r[8][0].u)
+	}
+	if r[1][0].u == 0 || r[11][0].u < r[1][0].u {
+		t.Fatalf("time %d, observed %d", r[1][0].u, r[11][0].u)
+	}
+	// not only the methods with fields send the span
+	if r := collector.records[1]; !bytes.Equal(r[9][0].b, traceID[:]) || !bytes.Equal(r[10][0].b, spanID[:]) {
+		t.Fatalf("Infof record without the span: %v", r)
+	}
+	if plain := collector.records[2]; plain[9] != nil || plain[2][0].u != 9 {
+		t.Fatalf("record without a span = %v", plain)
+	}
+}
+
+// valuesContext is a context that can't be compared with ==.
+type valuesContext struct {
+	context.Context
+	values map[string]string
+}
+
+func TestCtxUncomparableContext(t *testing.T) {
+	l, err := NewLoggerE(NewTestConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1},
+	}))
+	parent := l.WithTraceID(valuesContext{ctx, map[string]string{"a": "1"}})
+	parent.Ctx(valuesContext{ctx, map[string]string{"a": "2"}}).Info("same span")
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	collector := &fakeCollector{t: t, failures: 1}
+	srv := httptest.NewUnstartedServer(collector)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	s, err := openRecordSink(strings.Replace(srv.URL, "https", "otlp+grpcs", 1) + "?backoff=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.(*otlpSink).poster.client = srv.Client()
+	if _, err := s.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.calls != 2 || len(collector.records) != 1 {
+		t.Fatalf("%d records in %d calls, want 1 in 2", len(collector.records), collector.calls)
+	}
+	if body := collector.records[0].message(t, 5); string(body[1][0].b) != "hello" {
+		t.Fatalf("body = %q", body[1][0].b)
+	}
+}
+
+func TestOTLPURL(t *testing.T) {
+	for _, u := range []string{
+		"otlp://localhost?resource=nope",
+		"otlp://localhost?headers=x",
+	} {
+		if s, err := openRecordSink(u); err == nil {
+			_ = s.Close()
+			t.Errorf("%s: expected error", u)
+		}
+	}
+	s, err := openRecordSink("otlp+https://collector:4318")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if endpoint := s.(*otlpSink).endpoint; endpoint != "https://collector:4318/v1/logs" {
+		t.Fatalf("endpoint = %s", endpoint)
+	}
+}
+
+func TestGRPCStatusRetryable(t *testing.T) {
+	// a google.rpc.Status with an Any detail of typeURL
+	details := func(typeURL string) string {
+		detail := protoBytes(protoString(nil, 1, typeURL), 2, protoVarint(nil, 1, 5))
+		status := protoBytes(protoString(protoVarint(nil, 1, 8), 2, "quota"), 3, detail)
+		return base64.RawStdEncoding.EncodeToString(status)
+	}
+	for _, test := range []struct {
+		status, details string
+		retryable       bool
+	}{
+		{"14", "", true},
+		{"3", "", false},
+		{"8", "", false},
+		{"8", details("type.googleapis.com/google.rpc.QuotaFailure"), false},
+		{"8", details("type.googleapis.com/google.rpc.RetryInfo"), true},
+		{"8", "!", false},
+	} {
+		resp := &http.Response{Header: http.Header{}, Trailer: http.Header{
+			"Grpc-Status":             {test.status},
+			"Grpc-Status-Details-Bin": {test.details},
+		}}
+		if got := grpcStatus(resp).retryable(); got != test.retryable {
+			t.Errorf("status %s with details %q: retryable = %v", test.status, test.details, got)
+		}
+	}
+}
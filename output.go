@@ -0,0 +1,201 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// OutputConfig is an output with settings of its own, written in addition to
+// Config.OutputPaths. For example, to write everything to app.log, warnings
+// to stderr in color and errors to errors.log:
+//
+//	level: debug
+//	outputPaths: [app.log]
+//	outputs:
+//	  - {path: stderr, level: warn, encoding: console, enableColor: true}
+//	  - {path: errors.log, level: error, encoder: {timeFormat: rfc3339}}
+//
+// Fields added with With, Ctx or WithTraceID are written to every output.
+type OutputConfig struct {
+	// Path is a URL or file path, as in Config.OutputPaths.
+	Path string `json:"path" yaml:"path"`
+
+	// Level, if set, is the minimum level written to the output. Entries
+	// must be enabled by Config.Level as well. It can be changed at runtime
+	// with SetOutputLevel.
+	Level *Level `json:"level,omitempty" yaml:"level,omitempty"`
+
+	// Encoding is "json" or "console", Config.Encoding when empty.
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+
+	// EnableColor, if set, replaces Config.EnableColor. Colors are only used
+	// with the console encoding.
+	EnableColor *bool `json:"enableColor,omitempty" yaml:"enableColor,omitempty"`
+
+	// Encoder, if set, replaces the settings of Config.Encoder that are set
+	// in it, such as the time format or the key names.
+	Encoder *EncoderConfig `json:"encoder,omitempty" yaml:"encoder,omitempty"`
+
+	// Fallback is the same as Config.FallbackPaths, for this output.
+	Fallback []string `json:"fallback,omitempty" yaml:"fallback,omitempty"`
+}
+
+// validateOutput checks the output o of c.
+func (c *Config) validateOutput(o OutputConfig) []error {
+	var errs []error
+	if o.Path == "" {
+		errs = append(errs, errors.New("output with empty path"))
+	} else if err := checkOutputPath(c.outputURL(o.Path)); err != nil {
+		errs = append(errs, err)
+	}
+	for _, path := range o.Fallback {
+		if err := checkOutputPath(path); err != nil {
+			errs = append(errs, fmt.Errorf("output %q: fallback: %w", o.Path, err))
+		}
+	}
+	if o.Level != nil && (*o.Level < DebugLevel || *o.Level > FatalLevel) {
+		errs = append(errs, fmt.Errorf("output %q: not a valid logger Level: %d", o.Path, *o.Level))
+	}
+	if o.Encoding != "" {
+		if err := checkEncoding(o.Encoding); err != nil {
+			errs = append(errs, fmt.Errorf("output %q: %w", o.Path, err))
+		}
+	}
+	if o.Encoder != nil {
+		for _, err := range c.Encoder.override(*o.Encoder).validate() {
+			errs = append(errs, fmt.Errorf("output %q: %w", o.Path, err))
+		}
+	}
+	return errs
+}
+
+// outputEncoding is how the entries of an output are encoded.
+type outputEncoding struct {
+	encoding string
+	config   zapcore.EncoderConfig
+
+	// plain is config without colors, for the sinks encoding the entries
+	// themselves.
+	plain zapcore.EncoderConfig
+}
+
+// outputEncoding returns the encoding of the output o of c.
+func (c *Config) outputEncoding(o OutputConfig) (outputEncoding, error) {
+	encoding, color, e := c.Encoding, c.EnableColor, c.Encoder
+	if o.Encoding != "" {
+		encoding = o.Encoding
+	}
+	if o.EnableColor != nil {
+		color = *o.EnableColor
+	}
+	if o.Encoder != nil {
+		e = e.override(*o.Encoder)
+	}
+	// colors would end up in the JSON strings
+	config, err := e.zapEncoderConfig(c.ShortTime, color && encoding == "console")
+	if err != nil {
+		return outputEncoding{}, fmt.Errorf("output %q: %w", o.Path, err)
+	}
+	plain, _ := e.zapEncoderConfig(c.ShortTime, false)
+	return outputEncoding{encoding: encoding, config: config, plain: plain}, nil
+}
+
+func cloneOutputs(outputs []OutputConfig) []OutputConfig {
+	if outputs == nil {
+		return nil
+	}
+	cloned := make([]OutputConfig, len(outputs))
+	for i, o := range outputs {
+		cloned[i] = o
+		if o.Level != nil {
+			lvl := *o.Level
+			cloned[i].Level = &lvl
+		}
+		if o.EnableColor != nil {
+			color := *o.EnableColor
+			cloned[i].EnableColor = &color
+		}
+		if o.Encoder != nil {
+			e := *o.Encoder
+			cloned[i].Encoder = &e
+		}
+		cloned[i].Fallback = append([]string(nil), o.Fallback...)
+	}
+	return cloned
+}
+
+// newOutputLevels returns the runtime levels of outputs. Outputs without a
+// level get DebugLevel, so that only Config.Level applies until they're set.
+func newOutputLevels(outputs []OutputConfig) []zap.AtomicLevel {
+	levels := make([]zap.AtomicLevel, len(outputs))
+	for i, o := range outputs {
+		lvl := DebugLevel
+		if o.Level != nil {
+			lvl = *o.Level
+		}
+		levels[i] = zap.NewAtomicLevelAt(zapcore.Level(lvl))
+	}
+	return levels
+}
+
+// outputLevel returns the runtime level of the output with the given path.
+func (c *Config) outputLevel(path string) (zap.AtomicLevel, error) {
+	for i, o := range c.Outputs {
+		if o.Path == path && i < len(c.outputLevels) {
+			return c.outputLevels[i], nil
+		}
+	}
+	return zap.AtomicLevel{}, fmt.Errorf("no output %q in Config.Outputs", path)
+}
+
+// outputEnabler enables the levels enabled by both the logger and an output.
+type outputEnabler struct {
+	logger, output zapcore.LevelEnabler
+}
+
+func (e outputEnabler) Enabled(lvl zapcore.Level) bool {
+	return e.logger.Enabled(lvl) && e.output.Enabled(lvl)
+}
+
+// SetOutputLevel changes the minimum level of the output of Config.Outputs
+// with the given path atomically.
+func (l *logger) SetOutputLevel(path string, level Level) error {
+	lvl, err := l.config.outputLevel(path)
+	if err != nil {
+		return err
+	}
+	lvl.SetLevel(zapcore.Level(level))
+	return nil
+}
+
+// OutputLevel returns the minimum level in effect for the output of
+// Config.Outputs with the given path.
+func (l *logger) OutputLevel(path string) (Level, error) {
+	lvl, err := l.config.outputLevel(path)
+	if err != nil {
+		return 0, err
+	}
+	return Level(lvl.Level()), nil
+}
+
+// SetOutputLevel changes the minimum level of an output of the global logger,
+// see OutputConfig.
+func SetOutputLevel(path string, level Level) error {
+	l := current().l
+	if l == nil {
+		return errForeignGlobal
+	}
+	return l.SetOutputLevel(path, level)
+}
+
+// GetOutputLevel returns the minimum level of an output of the global logger.
+func GetOutputLevel(path string) (Level, error) {
+	l := current().l
+	if l == nil {
+		return 0, errForeignGlobal
+	}
+	return l.OutputLevel(path)
+}
@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputLevels(t *testing.T) {
+	dir := t.TempDir()
+	app, warn, errs := filepath.Join(dir, "app.log"), filepath.Join(dir, "warn.log"), filepath.Join(dir, "errors.log")
+	config, err := ParseConfig([]byte(`
+level: debug
+sampling: null
+outputPaths: [`+app+`]
+outputs:
+  - {path: `+warn+`, level: warn}
+  - {path: `+errs+`, level: error}
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keepGlobal(t)
+	SetConfig(config)
+
+	Debug("debug")
+	Warn("warn")
+	Error("error")
+	if got := strings.Count(readLog(t, app), "\n"); got != 3 {
+		t.Fatalf("app.log has %d entries, want 3", got)
+	}
+	if got := readLog(t, warn); strings.Contains(got, "debug") || !strings.Contains(got, "warn") {
+		t.Fatalf("warn.log = %s", got)
+	}
+	if got := readLog(t, errs); strings.Contains(got, "warn") || !strings.Contains(got, "error") {
+		t.Fatalf("errors.log = %s", got)
+	}
+
+	if err := SetOutputLevel(errs, WarnLevel); err != nil {
+		t.Fatal(err)
+	}
+	Warn("second warn")
+	if got := readLog(t, errs); !strings.Contains(got, "second warn") {
+		t.Fatalf("output level not changed: %s", got)
+	}
+	if lvl, err := GetOutputLevel(errs); err != nil || lvl != WarnLevel {
+		t.Fatalf("GetOutputLevel = %s, %v", lvl, err)
+	}
+	if lvl := *GetConfig().Outputs[1].Level; lvl != WarnLevel {
+		t.Fatalf("GetConfig output level = %s", lvl)
+	}
+	if err := SetOutputLevel("nope", WarnLevel); err == nil {
+		t.Fatal("expected error for unknown output")
+	}
+
+	// the logger level still applies to every output
+	SetLevel(ErrorLevel)
+	Warn("filtered")
+	if got := readLog(t, warn); strings.Contains(got, "filtered") {
+		t.Fatalf("warn.log = %s", got)
+	}
+}
+
+func TestDerivedOutputLevel(t *testing.T) {
+	warn := filepath.Join(t.TempDir(), "warn.log")
+	level := WarnLevel
+	config := NewProductionConfig()
+	config.Level = DebugLevel
+	config.Sampling = nil
+	config.OutputPaths = nil
+	config.Outputs = []OutputConfig{{Path: warn, Level: &level}}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := l.With("k", "v").(*logger)
+	if err := child.SetOutputLevel(warn, DebugLevel); err != nil {
+		t.Fatal(err)
+	}
+	child.Debug("from child")
+	l.Debug("from parent")
+	if got := readLog(t, warn); !strings.Contains(got, "from child") || !strings.Contains(got, "from parent") {
+		t.Fatalf("warn.log = %s", got)
+	}
+	if lvl, err := l.(*logger).OutputLevel(warn); err != nil || lvl != DebugLevel {
+		t.Fatalf("OutputLevel = %s, %v", lvl, err)
+	}
+}
+
+func TestDerivedSetLevel(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	config := NewProductionConfig()
+	config.Level = InfoLevel
+	config.Sampling = nil
+	config.OutputPaths = []string{out}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := l.With("k", "v")
+	child.SetLevel(DebugLevel)
+	child.Debug("from child")
+	l.Debug("from parent")
+	if got := readLog(t, out); !strings.Contains(got, "from child") || !strings.Contains(got, "from parent") {
+		t.Fatalf("out.log = %s", got)
+	}
+	if lvl := l.(*logger).Level(); lvl != DebugLevel {
+		t.Fatalf("parent level = %s", lvl)
+	}
+}
+
+func TestOutputEncoders(t *testing.T) {
+	dir := t.TempDir()
+	jsonLog, consoleLog := filepath.Join(dir, "app.json"), filepath.Join(dir, "app.txt")
+	config, err := ParseConfig([]byte(`
+encoding: json
+enableColor: true
+outputPaths: [`+jsonLog+`]
+outputs:
+  - path: `+consoleLog+`
+    encoding: console
+    encoder: {timeFormat: "15:04:05", messageKey: message}
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.With("service", "api").Ctx(context.Background()).Infow("hello", "k", "v")
+
+	got := readLog(t, jsonLog)
+	if !strings.HasPrefix(got, "{") || !strings.Contains(got, `"msg":"hello"`) ||
+		!strings.Contains(got, `"service":"api"`) || strings.Contains(got, "\x1b[") {
+		t.Fatalf("json output = %s", got)
+	}
+	got = readLog(t, consoleLog)
+	if strings.HasPrefix(got, "{") || !strings.Contains(got, "\x1b[34minfo\x1b[0m") ||
+		!strings.Contains(got, `"service": "api"`) || !strings.Contains(got, `"k": "v"`) {
+		t.Fatalf("console output = %q", got)
+	}
+	if _, err := time.Parse("15:04:05", strings.Fields(got)[0]); err != nil {
+		t.Fatalf("time format not applied: %q", got)
+	}
+
+	config.Outputs[0].Encoding = "xml"
+	config.Outputs[0].Encoder.TimeZone = "Nowhere/City"
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "xml") || !strings.Contains(err.Error(), "time zone") {
+		t.Fatalf("Validate() = %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	presetsMu sync.RWMutex
+	presets   = map[string]func(...FieldPair) *Config{
+		"production":  NewProductionConfig,
+		"development": NewDevelopmentConfig,
+		"test":        newTestConfig,
+		"cli":         newCLIConfig,
+		"k8s":         newK8sConfig,
+	}
+)
+
+// RegisterPreset makes a config constructor available under name to
+// NewConfigFromPreset, config files ("preset: name") and the PRESET
+// environment variable. Registering an existing name replaces it, which
+// includes the built-in "production", "development", "test", "cli" and "k8s"
+// presets. It panics if preset is nil.
+func RegisterPreset(name string, preset func(...FieldPair) *Config) {
+	if preset == nil {
+		panic("logger: RegisterPreset preset is nil")
+	}
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	presets[name] = preset
+}
+
+// NewConfigFromPreset returns the config built by the preset registered
+// under name, with Config.Preset set to name.
+func NewConfigFromPreset(name string, fields ...FieldPair) (*Config, error) {
+	presetsMu.RLock()
+	preset, ok := presets[name]
+	presetsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q", name)
+	}
+	config := preset(fields...)
+	config.Preset = name
+	return config, nil
+}
+
+// Presets returns the sorted names of the registered presets.
+func Presets() []string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTestConfig logs everything unsampled and uncolored. NewTestConfig writes
+// it to a test, the preset selected by name has none and writes to stdout.
+func newTestConfig(fields ...FieldPair) *Config {
+	config := NewDevelopmentConfig(fields...)
+	config.EnableColor = false
+	config.OutputPaths = []string{"stdout"}
+	config.Sampling = nil
+	return config
+}
+
+// newCLIConfig writes plain console lines without caller or stacktraces, for
+// command line tools.
+func newCLIConfig(fields ...FieldPair) *Config {
+	config := NewProductionConfig(fields...)
+	config.Encoding = "console"
+	config.ShortTime = true
+	config.DisableCaller = true
+	config.DisableStacktrace = true
+	config.Sampling = nil
+	return config
+}
+
+// newK8sConfig writes JSON to stdout with UTC timestamps, as expected by
+// container log collectors.
+func newK8sConfig(fields ...FieldPair) *Config {
+	config := NewProductionConfig(fields...)
+	config.OutputPaths = []string{"stdout"}
+	config.Encoder.TimeZone = "UTC"
+	return config
+}
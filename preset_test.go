@@ -0,0 +1,119 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	for _, name := range Presets() {
+		config, err := NewConfigFromPreset(name, FieldPair{"service", "api"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if config.Preset != name || config.InitialFields["service"] != "api" {
+			t.Fatalf("%s: unexpected config %+v", name, config)
+		}
+		if err := config.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	k8s, _ := NewConfigFromPreset("k8s")
+	if k8s.Encoding != "json" || k8s.OutputPaths[0] != "stdout" || k8s.Encoder.TimeZone != "UTC" {
+		t.Fatalf("k8s preset = %+v", k8s)
+	}
+	cli, _ := NewConfigFromPreset("cli")
+	if cli.Encoding != "console" || cli.EnableColor || !cli.DisableCaller {
+		t.Fatalf("cli preset = %+v", cli)
+	}
+
+	if _, err := NewConfigFromPreset("nope"); err == nil {
+		t.Fatal("expected error for unknown preset")
+	}
+}
+
+func TestRegisterPreset(t *testing.T) {
+	RegisterPreset("team", func(fields ...FieldPair) *Config {
+		config := NewProductionConfig(append(fields, FieldPair{"team", "payments"})...)
+		config.Level = WarnLevel
+		return config
+	})
+	t.Cleanup(func() {
+		presetsMu.Lock()
+		delete(presets, "team")
+		presetsMu.Unlock()
+	})
+	if !strings.Contains(strings.Join(Presets(), ","), "team") {
+		t.Fatalf("Presets() = %v", Presets())
+	}
+
+	config, err := ParseConfig([]byte("preset: team\nencoding: console\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Level != WarnLevel || config.Encoding != "console" || config.InitialFields["team"] != "payments" {
+		t.Fatalf("preset not used as base: %+v", config)
+	}
+
+	config = NewDefaultConfig(FieldPair{"service", "api"})
+	err = config.applyEnv("", envLookup(map[string]string{"LOG_PRESET": "team", "LOG_LEVEL": "error"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Preset != "team" || config.Level != ErrorLevel || config.InitialFields["service"] != "api" {
+		t.Fatalf("LOG_PRESET not applied: %+v", config)
+	}
+
+	if _, err := ParseConfig([]byte("preset: nope\n"), "yaml"); err == nil {
+		t.Fatal("expected error for unknown preset")
+	}
+}
+
+// logRecorder records the messages logged to a test.
+type logRecorder struct {
+	testing.TB
+
+	mu   sync.Mutex
+	logs []string
+}
+
+func (r *logRecorder) Log(args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, fmt.Sprint(args...))
+}
+
+func (r *logRecorder) logged() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.logs...)
+}
+
+func TestNewTestConfig(t *testing.T) {
+	var rec *logRecorder
+	var l Logger
+	t.Run("log", func(t *testing.T) {
+		rec = &logRecorder{TB: t}
+		config := NewTestConfig(rec, FieldPair{"service", "api"})
+		if config.Preset != "test" {
+			t.Fatalf("preset = %q", config.Preset)
+		}
+		var err error
+		if l, err = NewLoggerE(config); err != nil {
+			t.Fatal(err)
+		}
+		l.Debugw("to the test", "k", "v")
+		logs := rec.logged()
+		if len(logs) != 1 || !strings.Contains(logs[0], "to the test") || !strings.Contains(logs[0], `"service": "api"`) ||
+			strings.HasSuffix(logs[0], "\n") {
+			t.Fatalf("t.Log calls = %q", logs)
+		}
+	})
+	l.Info("after the test")
+	if logs := rec.logged(); len(logs) != 1 {
+		t.Fatalf("t.Log calls after the test = %q", logs)
+	}
+}
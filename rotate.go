@@ -0,0 +1,645 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// RotateScheme is the URL scheme of rotating file outputs, registered with
+// zap so it can be used in OutputPaths:
+//
+//	rotate:///var/log/app.log?maxsize=100MB&maxbackups=10
+//	rotate:///var/log/app-{hostname}.log?interval=daily&compress=zstd&maxage=168h
+//	rotate:logs/app.log?maxsize=10MB
+//
+// The last form is relative to the working directory. The query parameters
+// are the lowercased RotationConfig fields.
+//
+// The file name may contain the placeholders {hostname}, {pid} and {date},
+// the start of the current interval as 2006-01-02, or 2006-01-02T15 when
+// rotating hourly. When the name changes at the start of an interval, the
+// new file is opened and the previous one is kept as it is, instead of being
+// renamed.
+const RotateScheme = "rotate"
+
+const (
+	RotateDaily  = "daily"
+	RotateHourly = "hourly"
+)
+
+// RotationConfig rotates file outputs. When a file would grow beyond MaxSize,
+// or at the start of every Interval, it's renamed to <name>-<timestamp><ext>
+// and a new one is started. Rotated files are compressed and removed in the
+// background.
+type RotationConfig struct {
+	// MaxSize is the size files are rotated at, such as "100MB". Zero
+	// disables rotation by size.
+	MaxSize ByteSize `json:"maxSize" yaml:"maxSize"`
+
+	// Interval is "daily" or "hourly" to rotate at the start of every day or
+	// hour. Empty disables rotation by time.
+	Interval string `json:"interval" yaml:"interval"`
+
+	// UTC puts the interval boundaries and {date} in UTC instead of local
+	// time.
+	UTC bool `json:"utc" yaml:"utc"`
+
+	// Compress is "gzip" or "zstd" to compress rotated files.
+	Compress string `json:"compress" yaml:"compress"`
+
+	// MaxBackups is the number of rotated files kept, zero keeps them all.
+	MaxBackups int `json:"maxBackups" yaml:"maxBackups"`
+
+	// MaxAge removes rotated files last written longer ago than MaxAge.
+	MaxAge time.Duration `json:"maxAge" yaml:"maxAge"`
+
+	// MaxTotalSize removes the oldest rotated files once they take more space
+	// than MaxTotalSize together.
+	MaxTotalSize ByteSize `json:"maxTotalSize" yaml:"maxTotalSize"`
+}
+
+// rotation returns the rotation config of c, creating it when rotation was
+// disabled.
+func (c *Config) rotation() *RotationConfig {
+	if c.Rotation == nil {
+		c.Rotation = &RotationConfig{}
+	}
+	return c.Rotation
+}
+
+func (r *RotationConfig) validate() []error {
+	if r == nil {
+		return nil
+	}
+	var errs []error
+	if r.MaxSize < 0 || r.MaxTotalSize < 0 {
+		errs = append(errs, errors.New("rotation: negative max size or max total size"))
+	}
+	if r.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("rotation: negative max backups %d", r.MaxBackups))
+	}
+	if r.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("rotation: negative max age %s", r.MaxAge))
+	}
+	switch r.Interval {
+	case "", RotateDaily, RotateHourly:
+	default:
+		errs = append(errs, fmt.Errorf("rotation: not a valid interval: %q", r.Interval))
+	}
+	if _, ok := compressedExt[r.Compress]; !ok && r.Compress != "" {
+		errs = append(errs, fmt.Errorf("rotation: not a valid compression: %q", r.Compress))
+	}
+	return errs
+}
+
+func (r *RotationConfig) clone() *RotationConfig {
+	if r == nil {
+		return nil
+	}
+	cloned := *r
+	return &cloned
+}
+
+// url returns the rotate URL of file.
+func (r *RotationConfig) url(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	query := url.Values{}
+	if r.MaxSize > 0 {
+		query.Set("maxsize", r.MaxSize.String())
+	}
+	if r.Interval != "" {
+		query.Set("interval", r.Interval)
+	}
+	if r.UTC {
+		query.Set("utc", "true")
+	}
+	if r.Compress != "" {
+		query.Set("compress", r.Compress)
+	}
+	if r.MaxBackups > 0 {
+		query.Set("maxbackups", strconv.Itoa(r.MaxBackups))
+	}
+	if r.MaxAge > 0 {
+		query.Set("maxage", r.MaxAge.String())
+	}
+	if r.MaxTotalSize > 0 {
+		query.Set("maxtotalsize", r.MaxTotalSize.String())
+	}
+	u := url.URL{Scheme: RotateScheme, Path: filepath.ToSlash(file), RawQuery: query.Encode()}
+	return u.String()
+}
+
+// parseRotateURL returns the file and the rotation settings of a rotate URL.
+func parseRotateURL(u *url.URL) (string, RotationConfig, error) {
+	var r RotationConfig
+	file := u.Path
+	if u.Opaque != "" {
+		file = u.Opaque
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", r, fmt.Errorf("rotate URLs can't have a host: %s", u)
+	}
+	if file == "" {
+		return "", r, fmt.Errorf("rotate URL without a file: %s", u)
+	}
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		var err error
+		switch key {
+		case "maxsize":
+			err = r.MaxSize.UnmarshalText([]byte(value))
+		case "interval":
+			r.Interval = value
+		case "utc":
+			r.UTC, err = strconv.ParseBool(value)
+		case "compress":
+			r.Compress = value
+		case "maxbackups":
+			r.MaxBackups, err = strconv.Atoi(value)
+		case "maxage":
+			r.MaxAge, err = time.ParseDuration(value)
+		case "maxtotalsize":
+			err = r.MaxTotalSize.UnmarshalText([]byte(value))
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return "", r, fmt.Errorf("rotate URL %s: %s: %w", u, key, err)
+		}
+	}
+	return filepath.FromSlash(file), r, errors.Join(r.validate()...)
+}
+
+// periodStart returns the start of the interval t is in, or of the day when r
+// doesn't rotate by time.
+func (r *RotationConfig) periodStart(t time.Time) time.Time {
+	if r.UTC {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+	hour := 0
+	if r.Interval == RotateHourly {
+		hour = t.Hour()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, t.Location())
+}
+
+// periodEnd returns the start of the interval following t, or the zero time
+// when r doesn't rotate by time.
+func (r *RotationConfig) periodEnd(t time.Time) time.Time {
+	start := r.periodStart(t)
+	switch r.Interval {
+	case RotateDaily:
+		return start.AddDate(0, 0, 1)
+	case RotateHourly:
+		return start.Add(time.Hour)
+	}
+	return time.Time{}
+}
+
+var hostname = func() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}()
+
+// expand replaces the placeholders of template for the time t.
+func (r *RotationConfig) expand(template string, t time.Time) string {
+	date := "2006-01-02"
+	if r.Interval == RotateHourly {
+		date = "2006-01-02T15"
+	}
+	return strings.NewReplacer(
+		"{hostname}", hostname,
+		"{pid}", strconv.Itoa(os.Getpid()),
+		"{date}", r.periodStart(t).Format(date),
+	).Replace(template)
+}
+
+// outputURLs returns OutputPaths with the files turned into rotate URLs when
+// Rotation is set.
+func (c *Config) outputURLs() []string {
+	if c.Rotation == nil {
+		return c.OutputPaths
+	}
+	urls := make([]string, len(c.OutputPaths))
+	for i, path := range c.OutputPaths {
+		urls[i] = c.outputURL(path)
+	}
+	return urls
+}
+
+// outputURL returns path as a rotate URL when it's a file and Rotation is
+// set, and as it is otherwise.
+func (c *Config) outputURL(path string) string {
+	if file, ok := outputFile(path); ok && file != "" && c.Rotation != nil {
+		return c.Rotation.url(file)
+	}
+	return path
+}
+
+// outputFile returns the file written by an output path, if it's a plain
+// path or a file URL.
+func outputFile(path string) (string, bool) {
+	if path == "stdout" || path == "stderr" {
+		return "", false
+	}
+	// a single letter scheme is a Windows drive letter
+	if u, err := url.Parse(path); err == nil && len(u.Scheme) > 1 {
+		if u.Scheme != "file" {
+			return "", false
+		}
+		return u.Path, true
+	}
+	return path, true
+}
+
+func init() {
+	if err := zap.RegisterSink(RotateScheme, newRotateSink); err != nil {
+		panic(err)
+	}
+}
+
+func newRotateSink(u *url.URL) (zap.Sink, error) {
+	file, r, err := parseRotateURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return openRotatingFile(file, r)
+}
+
+var (
+	rotatingFilesMu sync.Mutex
+	// rotatingFiles are the open rotating files by absolute path template.
+	// Loggers writing to the same file share it, so that rotating from one
+	// logger doesn't leave the others writing to the renamed file.
+	rotatingFiles = make(map[string]*rotatingFile)
+)
+
+// openRotatingFile returns the rotating file at the path template, with its
+// settings replaced by r.
+func openRotatingFile(template string, r RotationConfig) (*rotatingFile, error) {
+	abs, err := filepath.Abs(template)
+	if err != nil {
+		return nil, err
+	}
+	rotatingFilesMu.Lock()
+	f, ok := rotatingFiles[abs]
+	if !ok {
+		f = &rotatingFile{template: abs, now: time.Now}
+		rotatingFiles[abs] = f
+	}
+	rotatingFilesMu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = r
+	// opening up front reports errors when the logger is built
+	if f.file == nil {
+		if err := f.open(f.now()); err != nil {
+			return nil, err
+		}
+	} else {
+		f.periodEnd = r.periodEnd(f.now())
+	}
+	return f, nil
+}
+
+// rotatingFile is a zap.Sink writing to a file that's rotated by size or
+// time.
+type rotatingFile struct {
+	template string
+
+	mu        sync.Mutex
+	now       func() time.Time
+	config    RotationConfig
+	path      string // template expanded when the file was opened
+	file      *os.File
+	size      int64
+	periodEnd time.Time
+
+	// background compresses and removes rotated files, one rotation at a
+	// time.
+	background   sync.WaitGroup
+	backgroundMu sync.Mutex
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if f.file == nil {
+		if err := f.open(now); err != nil {
+			return 0, err
+		}
+	}
+	if f.rotateDue(now, len(p)) {
+		if err := f.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		// start over with a fresh descriptor on the next write, in case the
+		// file was removed or its file system remounted
+		_ = f.file.Close()
+		f.file = nil
+	}
+	return n, err
+}
+
+// Sync flushes the file to disk.
+func (f *rotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file once the rotated files are compressed. It's opened
+// again by the next write, since other loggers may still share it.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.background.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *rotatingFile) open(now time.Time) error {
+	path := f.config.expand(f.template, now)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.path, f.file, f.size = path, file, info.Size()
+	// a file left by a previous run is rotated on the first write when it
+	// belongs to an earlier interval
+	start := now
+	if f.size > 0 && info.ModTime().Before(now) {
+		start = info.ModTime()
+	}
+	f.periodEnd = f.config.periodEnd(start)
+	return nil
+}
+
+func (f *rotatingFile) rotateDue(now time.Time, n int) bool {
+	if !f.periodEnd.IsZero() && !now.Before(f.periodEnd) {
+		return true
+	}
+	limit := int64(f.config.MaxSize)
+	return limit > 0 && f.size > 0 && f.size+int64(n) > limit
+}
+
+// rotate closes the current file and opens a new one. The current file is
+// renamed to a backup, unless the new one has another name.
+func (f *rotatingFile) rotate(now time.Time) error {
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+	rotated := f.path
+	if f.config.expand(f.template, now) == f.path {
+		backup, err := f.backupName(now)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(f.path, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		rotated = backup
+	}
+	if err := f.open(now); err != nil {
+		return err
+	}
+
+	config, active := f.config, f.path
+	f.background.Add(1)
+	go func() {
+		defer f.background.Done()
+		f.backgroundMu.Lock()
+		defer f.backgroundMu.Unlock()
+		if config.Compress != "" {
+			// failures leave the file uncompressed, it's still subject to
+			// the retention policy
+			_ = compressFile(rotated, config.Compress)
+		}
+		f.removeBackups(config, active, now)
+	}()
+	return nil
+}
+
+const backupTimeLayout = "2006-01-02T15-04-05.000000000"
+
+// backupName returns an unused backup file name for the time t.
+func (f *rotatingFile) backupName(t time.Time) (string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext) + "-" + t.Format(backupTimeLayout)
+	name := prefix + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); errors.Is(err, os.ErrNotExist) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		name = prefix + "." + strconv.Itoa(i) + ext
+	}
+}
+
+// compressedExt are the extensions of compressed backups by algorithm.
+var compressedExt = map[string]string{"gzip": ".gz", "zstd": ".zst"}
+
+// compressFile replaces name with its compressed version, keeping its
+// modification time for the retention policy.
+func compressFile(name, algorithm string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	target := name + compressedExt[algorithm]
+	tmp := target + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	var w io.WriteCloser
+	if algorithm == "zstd" {
+		if w, err = zstd.NewWriter(dst); err != nil {
+			return err
+		}
+	} else {
+		w = gzip.NewWriter(dst)
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, target); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+type backupFile struct {
+	path string
+	info os.FileInfo
+}
+
+// backups returns the rotated files of the template, oldest first: the
+// backups renamed by rotate and the files left behind when {pid} or {date}
+// changed, compressed or not. active is left out.
+func (f *rotatingFile) backups(active string) ([]backupFile, error) {
+	pattern := strings.NewReplacer(
+		"{hostname}", globEscape(hostname),
+		"{pid}", "*",
+		"{date}", "*",
+	).Replace(globEscape(f.template))
+	ext := filepath.Ext(pattern)
+	stem := strings.TrimSuffix(pattern, ext)
+
+	seen := make(map[string]bool)
+	var backups []backupFile
+	for _, base := range []string{stem + ext, stem + "-*" + ext} {
+		for _, suffix := range []string{"", ".gz", ".zst"} {
+			matches, err := filepath.Glob(base + suffix)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range matches {
+				if m == active || seen[m] || !f.isBackup(m) {
+					continue
+				}
+				seen[m] = true
+				if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+					backups = append(backups, backupFile{m, info})
+				}
+			}
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		ti, tj := backups[i].info.ModTime(), backups[j].info.ModTime()
+		if ti.Equal(tj) {
+			return backups[i].path < backups[j].path
+		}
+		return ti.Before(tj)
+	})
+	return backups, nil
+}
+
+// isBackup reports whether name is a rotated file of the template, that the
+// glob patterns of backups also match for other files, such as app-errors.log
+// for app.log: the template expanded, followed by the time of a backup and
+// its sequence number for backups, and compressed or not.
+func (f *rotatingFile) isBackup(name string) bool {
+	ext := filepath.Ext(f.template)
+	stem := strings.NewReplacer(
+		regexp.QuoteMeta("{hostname}"), regexp.QuoteMeta(hostname),
+		regexp.QuoteMeta("{pid}"), `[0-9]+`,
+		regexp.QuoteMeta("{date}"), `[0-9]{4}-[0-9]{2}-[0-9]{2}(?:T[0-9]{2})?`,
+	).Replace(regexp.QuoteMeta(strings.TrimSuffix(f.template, ext)))
+	pattern, err := regexp.Compile(`^` + stem + `(?:-([0-9T.-]{` + strconv.Itoa(len(backupTimeLayout)) + `})(?:\.[0-9]+)?)?` +
+		regexp.QuoteMeta(ext) + `(?:\.gz|\.zst)?$`)
+	if err != nil {
+		return false
+	}
+	m := pattern.FindStringSubmatch(name)
+	if m == nil {
+		return false
+	}
+	if m[1] == "" {
+		// the template expanded with another {pid} or {date}
+		return true
+	}
+	_, err = time.Parse(backupTimeLayout, m[1])
+	return err == nil
+}
+
+// globEscape escapes the characters of s that filepath.Glob would interpret.
+// Braces are left alone for the placeholders.
+func globEscape(s string) string {
+	if runtime.GOOS == "windows" {
+		// the escape character is the separator there
+		return strings.NewReplacer("[", "[[]", "*", "[*]", "?", "[?]").Replace(s)
+	}
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "*", `\*`, "?", `\?`).Replace(s)
+}
+
+// removeBackups applies the retention policy of config, keeping the newest
+// backups. Failures are ignored, they are retried on the next rotation.
+func (f *rotatingFile) removeBackups(config RotationConfig, active string, now time.Time) {
+	if config.MaxBackups <= 0 && config.MaxAge <= 0 && config.MaxTotalSize <= 0 {
+		return
+	}
+	backups, err := f.backups(active)
+	if err != nil {
+		return
+	}
+	var kept int
+	var total int64
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		kept++
+		total += b.info.Size()
+		if (config.MaxBackups > 0 && kept > config.MaxBackups) ||
+			(config.MaxAge > 0 && now.Sub(b.info.ModTime()) > config.MaxAge) ||
+			(config.MaxTotalSize > 0 && total > int64(config.MaxTotalSize)) {
+			_ = os.Remove(b.path)
+		}
+	}
+}
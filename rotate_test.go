@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]ByteSize{"512": 512, "64KB": 64 * KB, "100 mb": 100 * MB, "1.5GiB": 1536 * MB, "2T": 2 * TB} {
+		if got, err := ParseByteSize(s); err != nil || got != want {
+			t.Fatalf("ParseByteSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1MB", "10XB"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Fatalf("ParseByteSize(%q): expected error", s)
+		}
+	}
+	if s := (1536 * KB).String(); s != "1536KB" {
+		t.Fatalf("String() = %s", s)
+	}
+}
+
+func TestRotateURL(t *testing.T) {
+	u, _ := url.Parse("rotate:///var/log/app.log?maxsize=100MB&maxbackups=10")
+	file, r, err := parseRotateURL(u)
+	if err != nil || file != filepath.FromSlash("/var/log/app.log") || r.MaxSize != 100*MB || r.MaxBackups != 10 {
+		t.Fatalf("parseRotateURL = %s, %+v, %v", file, r, err)
+	}
+	u, _ = url.Parse("rotate:logs/app.log")
+	if file, _, err := parseRotateURL(u); err != nil || file != filepath.FromSlash("logs/app.log") {
+		t.Fatalf("parseRotateURL = %s, %v", file, err)
+	}
+	for _, bad := range []string{"rotate://host/app.log", "rotate:///app.log?maxsize=big", "rotate:///app.log?compress=1"} {
+		u, _ = url.Parse(bad)
+		if _, _, err := parseRotateURL(u); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f, err := openRotatingFile(path, RotationConfig{MaxSize: 100, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 10; i++ {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLog(t, path); got != line+line {
+		t.Fatalf("current file = %q", got)
+	}
+	f.background.Wait()
+	backups, err := f.backups(path)
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("backups = %v, %v", backups, err)
+	}
+	for _, b := range backups {
+		if got := readLog(t, b.path); got != line+line {
+			t.Fatalf("backup %s = %q", b.path, got)
+		}
+	}
+
+	// the file is reopened after it's closed or removed
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLog(t, path); got != line {
+		t.Fatalf("reopened file = %q", got)
+	}
+}
+
+func TestConfigRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	config, err := ParseConfig([]byte("outputPaths: ["+path+"]\nrotation: {maxSize: 1KB, maxBackups: 1}\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Rotation.MaxSize != KB || !strings.HasPrefix(config.outputURLs()[0], "rotate:///") {
+		t.Fatalf("rotation = %+v, outputs %v", config.Rotation, config.outputURLs())
+	}
+
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		l.Infow("filling the log file", "i", i)
+	}
+	if err := l.(interface{ Sync() error }).Sync(); err != nil {
+		t.Fatal(err)
+	}
+	rotatingFiles[path].background.Wait()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("files = %v, want the log and one backup", entries)
+	}
+	if info, _ := os.Stat(path); info.Size() > int64(KB) {
+		t.Fatalf("log file not rotated, size %d", info.Size())
+	}
+
+	config.Rotation.MaxBackups = -1
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected error for negative max backups")
+	}
+}
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+// openFakeClockFile opens a rotating file that uses c as its clock.
+func openFakeClockFile(t *testing.T, path string, r RotationConfig, c *fakeClock) *rotatingFile {
+	t.Helper()
+	f, err := openRotatingFile(path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = c.now
+	_ = f.file.Close()
+	if err := f.open(c.now()); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)}
+	path := filepath.Join(dir, "app-{date}.log")
+	f := openFakeClockFile(t, path, RotationConfig{Interval: RotateDaily, UTC: true, Compress: "gzip"}, clock)
+
+	_, _ = f.Write([]byte("march 1\n"))
+	clock.t = clock.t.Add(2 * time.Minute)
+	_, _ = f.Write([]byte("march 2\n"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readLog(t, filepath.Join(dir, "app-2024-03-02.log")); got != "march 2\n" {
+		t.Fatalf("current file = %q", got)
+	}
+	gz, err := os.Open(filepath.Join(dir, "app-2024-03-01.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	r, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "march 1\n" {
+		t.Fatalf("compressed file = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app-2024-03-01.log")); !os.IsNotExist(err) {
+		t.Fatalf("uncompressed file left behind: %v", err)
+	}
+
+	hourly := RotationConfig{Interval: RotateHourly, UTC: true}
+	if end := hourly.periodEnd(clock.t); !end.Equal(time.Date(2024, 3, 2, 1, 0, 0, 0, time.UTC)) {
+		t.Fatalf("hourly period end = %s", end)
+	}
+	if name := hourly.expand("{hostname}-{pid}-{date}", clock.t); name != fmt.Sprintf("%s-%d-2024-03-02T00", hostname, os.Getpid()) {
+		t.Fatalf("expanded name = %s", name)
+	}
+}
+
+func TestRotatingFileRetention(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{time.Now()}
+	path := filepath.Join(dir, "app.log")
+	r := RotationConfig{MaxSize: 10, Compress: "zstd", MaxAge: 48 * time.Hour, MaxTotalSize: 1500}
+	f := openFakeClockFile(t, path, r, clock)
+
+	old := filepath.Join(dir, "app-2020-01-01T00-00-00.000000000.log")
+	if err := os.WriteFile(old, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(old, clock.t.Add(-72*time.Hour), clock.t.Add(-72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(dir, "application.log")
+	if err := os.WriteFile(unrelated, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	random := make([]byte, 1000) // incompressible, so the total size is reached
+	for i := 0; i < 4; i++ {
+		_, _ = rand.Read(random)
+		_, _ = f.Write([]byte(hex.EncodeToString(random)[:1000]))
+		clock.t = clock.t.Add(time.Second)
+	}
+	f.background.Wait()
+
+	backups, err := f.backups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0].path, ".log.zst") {
+		t.Fatalf("backups = %v, want the newest compressed one", backups)
+	}
+	zr, err := os.Open(backups[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	dec, err := zstd.NewReader(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if data, err := io.ReadAll(dec); err != nil || len(data) != 1000 {
+		t.Fatalf("decompressed %d bytes, %v", len(data), err)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+}
+
+func TestRotatingFileRetentionSiblings(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{time.Now()}
+	path := filepath.Join(dir, "app.log")
+	f := openFakeClockFile(t, path, RotationConfig{MaxSize: 10, MaxBackups: 1}, clock)
+
+	// other logs of the directory that the backup glob patterns match
+	siblings := []string{"app-errors.log", "app-errors.log.gz", "app-2020-01-01.log", "app-2020-01-01T00-00-00.000000000-copy.log"}
+	for _, name := range siblings {
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, []byte("other\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, clock.t.Add(-time.Hour), clock.t.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = f.Write([]byte("0123456789\n"))
+		clock.t = clock.t.Add(time.Second)
+	}
+	f.background.Wait()
+
+	backups, err := f.backups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want the newest one", backups)
+	}
+	for _, name := range siblings {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s removed: %v", name, err)
+		}
+	}
+}
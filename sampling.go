@@ -0,0 +1,195 @@
+package logger
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingConfig sets a sampling policy. Within every Tick, the first Initial
+// entries with a given level and message are logged, then only every
+// Thereafter-th one; the rest are dropped. Levels overrides the policy per
+// level, for example to never sample errors:
+//
+//	sampling:
+//	  initial: 100
+//	  thereafter: 100
+//	  levels:
+//	    error: {disabled: true}
+type SamplingConfig struct {
+	// Disabled turns sampling off for all levels.
+	Disabled bool `json:"disabled" yaml:"disabled"`
+
+	// Tick is the interval the counts are reset at, one second when zero.
+	Tick time.Duration `json:"tick" yaml:"tick"`
+
+	Initial    int `json:"initial" yaml:"initial"`
+	Thereafter int `json:"thereafter" yaml:"thereafter"`
+
+	// Levels changes the policy of some levels, see LevelSamplingConfig. They
+	// can't turn sampling back on when Disabled is set.
+	Levels map[Level]LevelSamplingConfig `json:"levels,omitempty" yaml:"levels,omitempty"`
+
+	// OnDrop, if set, is called for every entry dropped by sampling. It's
+	// called synchronously and must be cheap.
+	OnDrop func(Level) `json:"-" yaml:"-"`
+}
+
+// LevelSamplingConfig changes the policy of a SamplingConfig for one level.
+// Disabled turns sampling off for the level. Initial and Thereafter replace
+// the ones of the SamplingConfig unless they're zero, which keeps them: a
+// level can't log no initial entries when the SamplingConfig logs some.
+type LevelSamplingConfig struct {
+	Disabled   bool `json:"disabled" yaml:"disabled"`
+	Initial    int  `json:"initial,omitempty" yaml:"initial,omitempty"`
+	Thereafter int  `json:"thereafter,omitempty" yaml:"thereafter,omitempty"`
+}
+
+const defaultSamplingTick = time.Second
+
+// samplingDropped counts the entries dropped by sampling, per level.
+var samplingDropped [FatalLevel - DebugLevel + 1]atomic.Uint64
+
+// SamplingDropped returns how many entries sampling has dropped per level
+// since the process started, across all loggers.
+func SamplingDropped() map[Level]uint64 {
+	dropped := make(map[Level]uint64, len(samplingDropped))
+	for i := range samplingDropped {
+		dropped[DebugLevel+Level(i)] = samplingDropped[i].Load()
+	}
+	return dropped
+}
+
+// sampling returns the sampling config of c, setting the defaults when
+// sampling was disabled.
+func (c *Config) sampling() *SamplingConfig {
+	if c.Sampling == nil {
+		c.Sampling = newDefaultSamplingConfig()
+	}
+	return c.Sampling
+}
+
+func (s *SamplingConfig) clone() *SamplingConfig {
+	if s == nil {
+		return nil
+	}
+	cloned := *s
+	if s.Levels != nil {
+		cloned.Levels = make(map[Level]LevelSamplingConfig, len(s.Levels))
+		for lvl, ls := range s.Levels {
+			cloned.Levels[lvl] = ls
+		}
+	}
+	return &cloned
+}
+
+func (s *SamplingConfig) validate() []error {
+	if s == nil {
+		return nil
+	}
+	var errs []error
+	if s.Tick < 0 {
+		errs = append(errs, fmt.Errorf("sampling: negative tick %s", s.Tick))
+	}
+	if s.Initial < 0 || s.Thereafter < 0 {
+		errs = append(errs, fmt.Errorf("sampling: negative initial or thereafter"))
+	}
+	for lvl, ls := range s.Levels {
+		if lvl < DebugLevel || lvl > FatalLevel {
+			errs = append(errs, fmt.Errorf("sampling: not a valid logger Level: %d", lvl))
+		}
+		if ls.Initial < 0 || ls.Thereafter < 0 {
+			errs = append(errs, fmt.Errorf("sampling: negative initial or thereafter for %s", lvl))
+		}
+	}
+	return errs
+}
+
+// levelSampling returns the policy in effect for lvl.
+func (s *SamplingConfig) levelSampling(lvl Level) LevelSamplingConfig {
+	ls := LevelSamplingConfig{Disabled: s.Disabled, Initial: s.Initial, Thereafter: s.Thereafter}
+	if o, ok := s.Levels[lvl]; ok {
+		ls.Disabled = ls.Disabled || o.Disabled
+		if o.Initial != 0 {
+			ls.Initial = o.Initial
+		}
+		if o.Thereafter != 0 {
+			ls.Thereafter = o.Thereafter
+		}
+	}
+	return ls
+}
+
+// wrapCore returns core sampled according to s.
+func (s *SamplingConfig) wrapCore(core zapcore.Core) zapcore.Core {
+	if s == nil || s.Disabled {
+		return core
+	}
+	tick := s.Tick
+	if tick == 0 {
+		tick = defaultSamplingTick
+	}
+	onDrop := s.OnDrop
+	hook := zapcore.SamplerHook(func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+		if dec&zapcore.LogDropped == 0 {
+			return
+		}
+		lvl := Level(ent.Level)
+		if lvl >= DebugLevel && lvl <= FatalLevel {
+			samplingDropped[lvl-DebugLevel].Add(1)
+		}
+		if onDrop != nil {
+			onDrop(lvl)
+		}
+	})
+
+	// levels sharing a policy share a sampler, which keeps With cheap
+	sc := &samplingCore{Core: core}
+	samplers := make(map[LevelSamplingConfig]int)
+	for i := range sc.levels {
+		ls := s.levelSampling(DebugLevel + Level(i))
+		if ls.Disabled {
+			sc.levels[i] = -1
+			continue
+		}
+		idx, ok := samplers[ls]
+		if !ok {
+			idx = len(sc.samplers)
+			samplers[ls] = idx
+			sampler := zapcore.NewSamplerWithOptions(core, tick, ls.Initial, ls.Thereafter, hook)
+			sc.samplers = append(sc.samplers, sampler)
+		}
+		sc.levels[i] = idx
+	}
+	return sc
+}
+
+// samplingCore routes every level to its sampler, or straight to the wrapped
+// core for the levels that aren't sampled.
+type samplingCore struct {
+	zapcore.Core
+	samplers []zapcore.Core
+	levels   [FatalLevel - DebugLevel + 1]int
+}
+
+func (c *samplingCore) With(fields []zapcore.Field) zapcore.Core {
+	cloned := &samplingCore{
+		Core:     c.Core.With(fields),
+		samplers: make([]zapcore.Core, len(c.samplers)),
+		levels:   c.levels,
+	}
+	for i, sampler := range c.samplers {
+		cloned.samplers[i] = sampler.With(fields)
+	}
+	return cloned
+}
+
+func (c *samplingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	lvl := Level(ent.Level)
+	if lvl < DebugLevel || lvl > FatalLevel || c.levels[lvl-DebugLevel] < 0 {
+		return c.Core.Check(ent, ce)
+	}
+	return c.samplers[c.levels[lvl-DebugLevel]].Check(ent, ce)
+}
@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSampling(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	var dropped atomic.Int64
+
+	config := NewProductionConfig()
+	config.OutputPaths = []string{out}
+	config.Sampling = &SamplingConfig{
+		Initial:    2,
+		Thereafter: 0,
+		Levels:     map[Level]LevelSamplingConfig{ErrorLevel: {Disabled: true}},
+		OnDrop:     func(Level) { dropped.Add(1) },
+	}
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := SamplingDropped()[InfoLevel]
+	child := l.With("child", "yes")
+	for i := 0; i < 5; i++ {
+		child.Info("sampled")
+		l.Error("never sampled")
+	}
+
+	got := readLog(t, out)
+	if n := strings.Count(got, "sampled"); n != 2+5 {
+		t.Fatalf("%d sampled lines in %q", n, got)
+	}
+	if n := strings.Count(got, "never sampled"); n != 5 {
+		t.Fatalf("%d error lines, want 5", n)
+	}
+	if dropped.Load() != 3 {
+		t.Fatalf("OnDrop called %d times, want 3", dropped.Load())
+	}
+	if n := SamplingDropped()[InfoLevel] - before; n != 3 {
+		t.Fatalf("SamplingDropped()[info] increased by %d, want 3", n)
+	}
+}
+
+func TestLevelSampling(t *testing.T) {
+	s := &SamplingConfig{
+		Initial:    100,
+		Thereafter: 10,
+		Levels: map[Level]LevelSamplingConfig{
+			WarnLevel:  {Disabled: true},
+			ErrorLevel: {Initial: 5},
+			FatalLevel: {Initial: 0, Thereafter: 0},
+		},
+	}
+	for lvl, want := range map[Level]LevelSamplingConfig{
+		InfoLevel:  {Initial: 100, Thereafter: 10},                 // not overridden
+		WarnLevel:  {Disabled: true, Initial: 100, Thereafter: 10}, // turned off
+		ErrorLevel: {Initial: 5, Thereafter: 10},                   // replaced unless zero
+		FatalLevel: {Initial: 100, Thereafter: 10},                 // zero keeps the policy
+	} {
+		if got := s.levelSampling(lvl); got != want {
+			t.Errorf("%s: %+v, want %+v", lvl, got, want)
+		}
+	}
+
+	// the levels can't turn sampling back on
+	s.Disabled = true
+	if ls := s.levelSampling(ErrorLevel); !ls.Disabled {
+		t.Errorf("error sampled when sampling is disabled: %+v", ls)
+	}
+	core := zapcore.NewNopCore()
+	if s.wrapCore(core) != core {
+		t.Error("core sampled when sampling is disabled")
+	}
+}
+
+func TestSamplingDisabled(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{out}
+	config.Sampling = nil
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 150; i++ {
+		l.Info("not sampled")
+	}
+	if n := strings.Count(readLog(t, out), "not sampled"); n != 150 {
+		t.Fatalf("%d lines, want 150", n)
+	}
+}
+
+func TestParseConfigSampling(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+sampling:
+  tick: 10s
+  initial: 5
+  levels:
+    error: {disabled: true}
+    warn: {thereafter: 10}
+`), "yaml")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	s := config.Sampling
+	if s.Tick.Seconds() != 10 || s.Initial != 5 || s.Thereafter != 100 {
+		t.Fatalf("sampling = %+v", s)
+	}
+	if !s.levelSampling(ErrorLevel).Disabled || s.levelSampling(WarnLevel).Thereafter != 10 {
+		t.Fatalf("sampling levels = %+v", s.Levels)
+	}
+
+	if _, err := ParseConfig([]byte(`{"sampling": {"levels": {"loud": {}}}}`), "json"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
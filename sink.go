@@ -0,0 +1,350 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// record is an entry as written to a recordSink.
+type record struct {
+	zapcore.Entry
+
+	// Fields are the fields of the entry and of the logger, as decoded by a
+	// zapcore.MapObjectEncoder.
+	Fields map[string]interface{}
+
+	// Line is the entry encoded by the encoder of the output.
+	Line []byte
+
+	// SpanContext is the span context of the entries logged with Ctx or
+	// WithTraceID, when the context has one.
+	SpanContext trace.SpanContext
+
+	// entry is a copy of Line for the sinks that may drop the record in the
+	// background, to write it to the fallbacks then.
+	entry []byte
+}
+
+// spanContextKey is the key of the field carrying the span context of an
+// entry to the record sinks. As a zapcore.SkipType field, the encoders ignore
+// it.
+const spanContextKey = "logger.spanContext"
+
+func spanContextField(sc trace.SpanContext) zapcore.Field {
+	return zapcore.Field{Key: spanContextKey, Type: zapcore.SkipType, Interface: sc}
+}
+
+// spanContextOf returns the span context of the last spanContextField of
+// fields, sc if there's none.
+func spanContextOf(sc trace.SpanContext, fields []zapcore.Field) trace.SpanContext {
+	for _, f := range fields {
+		if f.Type == zapcore.SkipType && f.Key == spanContextKey {
+			sc, _ = f.Interface.(trace.SpanContext)
+		}
+	}
+	return sc
+}
+
+// recordValue returns the value v of a field of a record as a string,
+// JSON-encoded unless it's already a string.
+func recordValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// recordSink is a zap.Sink that makes use of the structure of the entries,
+// such as their level and fields, instead of only their encoded form. Its
+// Write method is used when it's opened through zap, and should treat the
+// bytes as an InfoLevel entry.
+type recordSink interface {
+	zap.Sink
+	writeRecord(r *record) error
+}
+
+// encodingSink is a recordSink that encodes the records itself, such as to
+// leave out the fields it sends separately. It's given the encoding and the
+// encoder config of its output, without colors.
+type encodingSink interface {
+	recordSink
+	setEncoder(encoding string, config zapcore.EncoderConfig)
+}
+
+// fallbackSink is a recordSink that drops entries in the background, such as
+// the ones of a batcher. It writes them to the fallbacks of its output.
+type fallbackSink interface {
+	recordSink
+	setFallback(f *fallbackChain)
+}
+
+// encodeRecord encodes r with enc and the fields of r, sorted by key, apart
+// from the ones omit returns true for.
+func encodeRecord(enc zapcore.Encoder, r *record, omit func(key string) bool) (*buffer.Buffer, error) {
+	keys := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		if omit == nil || !omit(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	fields := make([]zapcore.Field, len(keys))
+	for i, k := range keys {
+		fields[i] = zap.Any(k, r.Fields[k])
+	}
+	return enc.EncodeEntry(r.Entry, fields)
+}
+
+// recordSinks are the factories of record sinks by URL scheme.
+var recordSinks = make(map[string]func(*url.URL) (recordSink, error))
+
+// registerRecordSink registers the factory of a record sink for scheme, with
+// zap as well.
+func registerRecordSink(scheme string, factory func(*url.URL) (recordSink, error)) {
+	recordSinks[scheme] = factory
+	err := zap.RegisterSink(scheme, func(u *url.URL) (zap.Sink, error) {
+		return factory(u)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// openRecordSink opens the output at rawURL when it's a record sink, and
+// returns nil otherwise.
+func openRecordSink(rawURL string) (recordSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil
+	}
+	factory, ok := recordSinks[u.Scheme]
+	if !ok {
+		return nil, nil
+	}
+	return factory(u)
+}
+
+// outputEntry is an encoded entry on its way to an output.
+type outputEntry struct {
+	level Level
+	buf   *buffer.Buffer
+	rec   *record // set for record sinks only
+}
+
+// entryWriter writes the entries of an outputCore.
+type entryWriter interface {
+	writeEntry(e outputEntry) error
+	Sync() error
+}
+
+// syncWriter writes encoded entries to a zapcore.WriteSyncer.
+type syncWriter struct {
+	out zapcore.WriteSyncer
+}
+
+func (w syncWriter) writeEntry(e outputEntry) error {
+	_, err := w.out.Write(e.buf.Bytes())
+	e.buf.Free()
+	return err
+}
+
+func (w syncWriter) Sync() error {
+	return w.out.Sync()
+}
+
+// errOutputStopped is the error of the entries written through a core that
+// was replaced, once its outputs are stopped.
+var errOutputStopped = errors.New("logger: output stopped by a reload")
+
+// stoppableWriter rejects the entries written once it's stopped. Stopping it
+// waits for the writes in progress, so that out can be closed afterwards.
+type stoppableWriter struct {
+	out entryWriter
+
+	mu      sync.RWMutex
+	stopped bool
+}
+
+func (w *stoppableWriter) writeEntry(e outputEntry) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.stopped {
+		e.buf.Free()
+		return errOutputStopped
+	}
+	return w.out.writeEntry(e)
+}
+
+func (w *stoppableWriter) Sync() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.stopped {
+		return nil
+	}
+	return w.out.Sync()
+}
+
+func (w *stoppableWriter) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+}
+
+// recordWriter writes entries to a recordSink, and the encoded entries it
+// fails to write to the fallbacks of the output.
+type recordWriter struct {
+	out      recordSink
+	fallback *fallbackChain
+}
+
+func (w recordWriter) writeEntry(e outputEntry) error {
+	if _, ok := w.out.(fallbackSink); ok && len(w.fallback.outs) > 0 {
+		e.rec.entry = append([]byte(nil), e.buf.Bytes()...)
+	}
+	err := w.out.writeRecord(e.rec)
+	if err != nil {
+		err = w.fallback.failed(e.buf.Bytes(), err)
+	}
+	e.buf.Free()
+	return err
+}
+
+func (w recordWriter) Sync() error {
+	return errors.Join(w.out.Sync(), w.fallback.sync())
+}
+
+// outputCore is a zapcore.Core like the one of zapcore.NewCore, handing the
+// encoded entries to an entryWriter. For record sinks it also keeps the
+// fields added with With as a map, and the span context among them.
+type outputCore struct {
+	zapcore.LevelEnabler
+	enc         zapcore.Encoder
+	records     bool
+	fields      map[string]interface{}
+	spanContext trace.SpanContext
+	out         entryWriter
+}
+
+func newOutputCore(enc zapcore.Encoder, out entryWriter, records bool, enab zapcore.LevelEnabler) *outputCore {
+	return &outputCore{LevelEnabler: enab, enc: enc, records: records, out: out}
+}
+
+func (c *outputCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	if c.records {
+		clone.fields = c.recordFields(fields)
+		clone.spanContext = spanContextOf(c.spanContext, fields)
+	}
+	return &clone
+}
+
+// recordFields returns the fields of c with fields added.
+func (c *outputCore) recordFields(fields []zapcore.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for k, v := range c.fields {
+		enc.Fields[k] = v
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return enc.Fields
+}
+
+func (c *outputCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *outputCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	e := outputEntry{level: Level(ent.Level), buf: buf}
+	if c.records {
+		e.rec = &record{
+			Entry:       ent,
+			Fields:      c.recordFields(fields),
+			SpanContext: spanContextOf(c.spanContext, fields),
+			Line:        buf.Bytes(),
+		}
+	}
+	if err := c.out.writeEntry(e); err != nil {
+		return err
+	}
+	if ent.Level > zapcore.ErrorLevel {
+		// the process may be about to exit
+		return c.Sync()
+	}
+	return nil
+}
+
+func (c *outputCore) Sync() error {
+	return c.out.Sync()
+}
+
+// OutputError is a failure of an output that writes in the background, such
+// as the HTTP outputs, reported on the Diagnostics channel.
+type OutputError struct {
+	// Output is the URL of the output, without its query and password.
+	Output string
+
+	// Dropped is the number of entries lost with the failure.
+	Dropped int
+
+	Err error
+}
+
+func (e *OutputError) Error() string {
+	if e.Dropped > 0 {
+		return fmt.Sprintf("output %s: %d entries dropped: %v", e.Output, e.Dropped, e.Err)
+	}
+	return fmt.Sprintf("output %s: %v", e.Output, e.Err)
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+var diagnostics = make(chan *OutputError, 64)
+
+// Diagnostics returns the channel on which the outputs writing in the
+// background report their failures, since there's no caller to return them
+// to. Failures are discarded when the channel is full, they're counted in
+// OutputStatuses all the same.
+func Diagnostics() <-chan *OutputError {
+	return diagnostics
+}
+
+func reportOutputError(e *OutputError) {
+	countOutputFailure(e.Output, e.Err, 0, e.Dropped)
+	sendDiagnostic(e)
+}
+
+// sendDiagnostic sends e on the Diagnostics channel, without counting it in
+// OutputStatuses.
+func sendDiagnostic(e *OutputError) {
+	select {
+	case diagnostics <- e:
+	default:
+	}
+}
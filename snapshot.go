@@ -0,0 +1,214 @@
+package logger
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// ConfigSnapshot is the effective configuration of a logger, with every
+// default resolved. It serializes to JSON and YAML, for example to print it at
+// startup or serve it from a debug endpoint, and Diff compares two snapshots.
+type ConfigSnapshot struct {
+	Preset            string                 `json:"preset,omitempty" yaml:"preset,omitempty"`
+	Level             Level                  `json:"level" yaml:"level"`
+	Development       bool                   `json:"development" yaml:"development"`
+	DisableCaller     bool                   `json:"disableCaller" yaml:"disableCaller"`
+	DisableStacktrace bool                   `json:"disableStacktrace" yaml:"disableStacktrace"`
+	CallerSkip        int                    `json:"callerSkip" yaml:"callerSkip"`
+	Encoding          string                 `json:"encoding" yaml:"encoding"`
+	EnableColor       bool                   `json:"enableColor" yaml:"enableColor"`
+	Encoder           EncoderConfig          `json:"encoder" yaml:"encoder"`
+	OutputPaths       []string               `json:"outputPaths" yaml:"outputPaths"`
+	FallbackPaths     []string               `json:"fallbackPaths" yaml:"fallbackPaths"`
+	ErrorOutputPaths  []string               `json:"errorOutputPaths" yaml:"errorOutputPaths"`
+	Outputs           []OutputConfig         `json:"outputs" yaml:"outputs"`
+	Rotation          *RotationSnapshot      `json:"rotation" yaml:"rotation"`
+	Async             *AsyncConfig           `json:"async" yaml:"async"`
+	Sampling          *SamplingSnapshot      `json:"sampling" yaml:"sampling"`
+	InitialFields     map[string]interface{} `json:"initialFields" yaml:"initialFields"`
+}
+
+// SamplingSnapshot is the sampling policy in effect for every level. It's nil
+// in a ConfigSnapshot when sampling is disabled.
+type SamplingSnapshot struct {
+	Tick   string                        `json:"tick" yaml:"tick"`
+	Levels map[Level]LevelSamplingConfig `json:"levels" yaml:"levels"`
+}
+
+// RotationSnapshot is the rotation of file outputs. It's nil in a
+// ConfigSnapshot when rotation is disabled.
+type RotationSnapshot struct {
+	MaxSize      ByteSize `json:"maxSize" yaml:"maxSize"`
+	Interval     string   `json:"interval" yaml:"interval"`
+	UTC          bool     `json:"utc" yaml:"utc"`
+	Compress     string   `json:"compress" yaml:"compress"`
+	MaxBackups   int      `json:"maxBackups" yaml:"maxBackups"`
+	MaxAge       string   `json:"maxAge" yaml:"maxAge"`
+	MaxTotalSize ByteSize `json:"maxTotalSize" yaml:"maxTotalSize"`
+}
+
+// ConfigChange is a setting that differs between two snapshots. Path is the
+// dotted JSON path of the setting, such as "encoder.timeKey".
+type ConfigChange struct {
+	Path string      `json:"path" yaml:"path"`
+	Old  interface{} `json:"old" yaml:"old"`
+	New  interface{} `json:"new" yaml:"new"`
+}
+
+// GetConfigSnapshot returns the effective configuration of the global logger,
+// with the level currently in effect. It's empty when the global logger was
+// replaced by a Logger that isn't implemented by this package.
+func GetConfigSnapshot() ConfigSnapshot {
+	if l := current().l; l != nil {
+		return l.config.clone().Snapshot()
+	}
+	return ConfigSnapshot{}
+}
+
+// Snapshot returns the configuration a logger built from c would use.
+func (c *Config) Snapshot() ConfigSnapshot {
+	s := ConfigSnapshot{
+		Preset:            c.Preset,
+		Level:             c.Level,
+		Development:       c.Development,
+		DisableCaller:     c.DisableCaller,
+		DisableStacktrace: c.DisableStacktrace,
+		CallerSkip:        c.CallerSkip,
+		Encoding:          c.Encoding,
+		EnableColor:       c.EnableColor,
+		Encoder:           c.effectiveEncoder(),
+		OutputPaths:       append([]string(nil), c.OutputPaths...),
+		FallbackPaths:     append([]string(nil), c.FallbackPaths...),
+		ErrorOutputPaths:  append([]string(nil), c.ErrorOutputPaths...),
+		Outputs:           cloneOutputs(c.Outputs),
+		InitialFields:     make(map[string]interface{}, len(c.InitialFields)),
+	}
+	if c.Async != nil {
+		s.Async = &AsyncConfig{QueueSize: c.Async.QueueSize, Policy: c.Async.Policy}
+		if s.Async.QueueSize == 0 {
+			s.Async.QueueSize = defaultAsyncQueueSize
+		}
+		if s.Async.Policy == "" {
+			s.Async.Policy = AsyncBlock
+		}
+	}
+	for k, v := range c.InitialFields {
+		s.InitialFields[k] = v
+	}
+	if c.Sampling != nil && !c.Sampling.Disabled {
+		tick := c.Sampling.Tick
+		if tick == 0 {
+			tick = defaultSamplingTick
+		}
+		s.Sampling = &SamplingSnapshot{Tick: tick.String(), Levels: make(map[Level]LevelSamplingConfig)}
+		for lvl := DebugLevel; lvl <= FatalLevel; lvl++ {
+			s.Sampling.Levels[lvl] = c.Sampling.levelSampling(lvl)
+		}
+	}
+	if r := c.Rotation; r != nil {
+		s.Rotation = &RotationSnapshot{
+			MaxSize:      r.MaxSize,
+			Interval:     r.Interval,
+			UTC:          r.UTC,
+			Compress:     r.Compress,
+			MaxBackups:   r.MaxBackups,
+			MaxAge:       r.MaxAge.String(),
+			MaxTotalSize: r.MaxTotalSize,
+		}
+	}
+	return s
+}
+
+// effectiveEncoder returns c.Encoder with the defaults filled in.
+func (c *Config) effectiveEncoder() EncoderConfig {
+	e := c.Encoder
+	keys := []struct {
+		key *string
+		dft string
+	}{
+		{&e.TimeKey, "ts"},
+		{&e.LevelKey, "level"},
+		{&e.NameKey, "logger"},
+		{&e.CallerKey, "caller"},
+		{&e.MessageKey, "msg"},
+		{&e.StacktraceKey, "stacktrace"},
+	}
+	for _, k := range keys {
+		if *k.key == "" {
+			*k.key = k.dft
+		}
+	}
+	if e.TimeFormat == "" {
+		e.TimeFormat = "iso8601"
+		if c.ShortTime {
+			e.TimeFormat = shortTimeLayout
+		}
+	}
+	if e.TimeZone == "" {
+		e.TimeZone = "Local"
+	}
+	if e.DurationFormat == "" {
+		e.DurationFormat = "seconds"
+	}
+	if e.CallerFormat == "" {
+		e.CallerFormat = "short"
+	}
+	if e.LevelFormat == "" {
+		e.LevelFormat = "lowercase"
+	}
+	return e
+}
+
+// Diff returns the settings that differ from s in other, sorted by path.
+func (s ConfigSnapshot) Diff(other ConfigSnapshot) []ConfigChange {
+	var changes []ConfigChange
+	diffTree("", snapshotTree(s), snapshotTree(other), &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// snapshotTree returns s as decoded JSON. Initial fields are kept as Go
+// values since they may not marshal to JSON.
+func snapshotTree(s ConfigSnapshot) map[string]interface{} {
+	fields := s.InitialFields
+	s.InitialFields = nil
+
+	var tree map[string]interface{}
+	data, _ := json.Marshal(s) // only initial fields can fail to marshal
+	_ = json.Unmarshal(data, &tree)
+	initialFields := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		initialFields[k] = v
+	}
+	tree["initialFields"] = initialFields
+	return tree
+}
+
+// diffTree appends the differences between a and b to changes, descending
+// into objects present on both sides. Lists are compared as a whole.
+func diffTree(path string, a, b interface{}, changes *[]ConfigChange) {
+	ma, aok := a.(map[string]interface{})
+	mb, bok := b.(map[string]interface{})
+	if !aok || !bok {
+		if !reflect.DeepEqual(a, b) {
+			*changes = append(*changes, ConfigChange{Path: path, Old: a, New: b})
+		}
+		return
+	}
+	for k, v := range ma {
+		diffTree(joinPath(path, k), v, mb[k], changes)
+	}
+	for k, v := range mb {
+		if _, ok := ma[k]; !ok {
+			diffTree(joinPath(path, k), nil, v, changes)
+		}
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestGetConfigSnapshot(t *testing.T) {
+	config := NewDevelopmentConfig(FieldPair{"service", "api"})
+	config.OutputPaths = []string{"stdout"}
+	config.FallbackPaths = []string{"stderr"}
+	keepGlobal(t)
+	SetConfig(config)
+	SetLevel(WarnLevel)
+
+	s := GetConfigSnapshot()
+	if s.Level != WarnLevel || s.Encoding != "console" || s.InitialFields["service"] != "api" {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	if len(s.FallbackPaths) != 1 || s.FallbackPaths[0] != "stderr" ||
+		len(s.ErrorOutputPaths) != 1 || s.ErrorOutputPaths[0] != "stderr" {
+		t.Fatalf("fallbacks %v, error outputs %v", s.FallbackPaths, s.ErrorOutputPaths)
+	}
+	if s.Encoder.TimeKey != "ts" || s.Encoder.TimeFormat != shortTimeLayout || s.Encoder.LevelFormat != "lowercase" {
+		t.Fatalf("encoder defaults not resolved: %+v", s.Encoder)
+	}
+	if s.Sampling == nil || s.Sampling.Tick != "1s" || s.Sampling.Levels[ErrorLevel].Initial != 100 {
+		t.Fatalf("sampling = %+v", s.Sampling)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"level":"warn"`) || !strings.Contains(string(data), `"error":{`) {
+		t.Fatalf("json = %s", data)
+	}
+	data, err = yaml.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "level: warn") {
+		t.Fatalf("yaml = %s", data)
+	}
+}
+
+func TestConfigSnapshotDiff(t *testing.T) {
+	a := NewProductionConfig(FieldPair{"service", "api"})
+	b := NewProductionConfig(FieldPair{"service", "web"})
+	b.Level = DebugLevel
+	b.Encoder.TimeKey = "@timestamp"
+	b.Sampling = nil
+	b.OutputPaths = []string{"stdout"}
+	b.FallbackPaths = []string{"stderr"}
+	b.ErrorOutputPaths = nil
+
+	changes := a.Snapshot().Diff(b.Snapshot())
+	paths := make([]string, 0, len(changes))
+	for _, c := range changes {
+		paths = append(paths, c.Path)
+	}
+	want := "encoder.timeKey,errorOutputPaths,fallbackPaths,initialFields.service,level,outputPaths,sampling"
+	if strings.Join(paths, ",") != want {
+		t.Fatalf("changed paths = %v, want %s", paths, want)
+	}
+	if changes[0].Old != "ts" || changes[0].New != "@timestamp" {
+		t.Fatalf("change = %+v", changes[0])
+	}
+
+	if changes := a.Snapshot().Diff(a.Snapshot()); len(changes) != 0 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+}
@@ -0,0 +1,586 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The jsonl outputs stream entries as JSON lines to an agent such as Vector
+// or Logstash, jsonl+tcp over TCP, jsonl+tls over TLS and jsonl+unix over a
+// unix socket:
+//
+//	jsonl+tcp://localhost:9000?spoolSize=256MB
+//	jsonl+tls://logstash:5044?ca=/etc/ssl/agent.pem
+//	jsonl+unix:///var/run/vector.sock
+//
+// The entries are encoded as JSON with the encoder settings of the output and
+// sent from a background goroutine, which reconnects when the connection is
+// lost. In the meantime, they're appended to a spool file, which is sent in
+// order once the agent is back, so callers never wait for it. The query
+// parameters are:
+//
+//   - spool: the path of the spool file, logger-<address>.spool in the
+//     temporary directory by default. Entries left in it by a previous run
+//     are sent first. A spool is locked by the process using it, another
+//     process fails to open it, or uses logger-<address>-<pid>.spool for the
+//     default one. Within a process, the output replacing another one on a
+//     reload takes over its spool once it's closed.
+//   - spoolSize: the maximum size of the spool, 64MB by default. The entries
+//     that don't fit are dropped.
+//   - spoolWarn: the percentage of spoolSize above which ErrSpoolFilling is
+//     reported on the Diagnostics channel, 80 by default
+//   - timeout: the timeout to connect and to write, 10s by default
+//   - backoff, maxBackoff: the delay before reconnecting, doubled on every
+//     failure, 100ms and 10s by default
+//   - ca, cert, key: for jsonl+tls, PEM files with the certificates of the
+//     authorities trusted instead of the system ones, and the client
+//     certificate and its key
+//   - serverName, insecureSkipVerify: for jsonl+tls, the name the server
+//     certificate is checked against instead of the host, and "true" to skip
+//     the check
+const (
+	JSONLinesTCPScheme  = "jsonl+tcp"
+	JSONLinesTLSScheme  = "jsonl+tls"
+	JSONLinesUnixScheme = "jsonl+unix"
+)
+
+// socketBufferSize is the size of the entries kept in memory until they're
+// sent, and of the chunks of the spool sent at once.
+const socketBufferSize = 256 << 10
+
+var (
+	// ErrSpoolFilling is reported when a spool goes above its warning
+	// threshold, and again when it does after it's been emptied.
+	ErrSpoolFilling = errors.New("spool filling up")
+
+	// ErrSpoolFull is the error of the entries dropped because the spool
+	// was full.
+	ErrSpoolFull = errors.New("spool full")
+)
+
+var spoolName = regexp.MustCompile(`[^\w.\-]+`)
+
+var errSpoolLocked = errors.New("used by another process")
+
+// spoolUsers are the sinks of the process using a spool, by path. The first
+// one holds the spool, the others wait for it to be closed, as the sinks of a
+// reload do.
+var spoolUsers = struct {
+	sync.Mutex
+	m map[string][]*socketSink
+}{m: make(map[string][]*socketSink)}
+
+func init() {
+	registerRecordSink(JSONLinesTCPScheme, newSocketSink)
+	registerRecordSink(JSONLinesTLSScheme, newSocketSink)
+	registerRecordSink(JSONLinesUnixScheme, newSocketSink)
+}
+
+type socketSink struct {
+	output     string
+	network    string
+	addr       string
+	tls        *tls.Config
+	timeout    time.Duration
+	reconnect  httpOptions // backoff and maxBackoff
+	spoolPath  string
+	spoolLimit int64
+	spoolWarn  int64
+	enc        zapcore.Encoder
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   [][]byte // entries older than the ones of the spool
+	bytes     int
+	spool     *os.File // nil while another sink holds it
+	read      int64    // offset of the next entry of the spool to send
+	size      int64
+	warned    bool
+	connected bool
+	closed    bool
+	dropped   int
+
+	closeOnce sync.Once
+	ctx       context.Context // canceled by Close
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func newSocketSink(u *url.URL) (recordSink, error) {
+	s := &socketSink{
+		output:     outputName(u),
+		timeout:    10 * time.Second,
+		reconnect:  httpOptions{backoff: 100 * time.Millisecond, maxBackoff: 10 * time.Second},
+		spoolLimit: int64(64 * MB),
+		done:       make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if u.Scheme == JSONLinesUnixScheme {
+		s.network, s.addr = "unix", u.Path
+	} else {
+		s.network, s.addr = "tcp", u.Host
+	}
+	if s.addr == "" {
+		return nil, fmt.Errorf("output %s: no address", s.output)
+	}
+
+	if u.Scheme == JSONLinesTLSScheme {
+		s.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	warn := int64(80)
+	var certFile, keyFile string
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		if (key == "ca" || key == "cert" || key == "key" || key == "serverName" ||
+			key == "insecureSkipVerify") && u.Scheme != JSONLinesTLSScheme {
+			return nil, fmt.Errorf("output %s: %s only applies to %s", s.output, key, JSONLinesTLSScheme)
+		}
+		var err error
+		switch key {
+		case "spool":
+			s.spoolPath = value
+		case "spoolSize":
+			var size ByteSize
+			size, err = ParseByteSize(value)
+			if err == nil && size <= 0 {
+				err = errors.New("must be positive")
+			}
+			s.spoolLimit = int64(size)
+		case "spoolWarn":
+			warn, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && (warn <= 0 || warn > 100) {
+				err = errors.New("must be a percentage")
+			}
+		case "timeout":
+			s.timeout, err = parsePositiveDuration(value)
+		case "backoff":
+			s.reconnect.backoff, err = parsePositiveDuration(value)
+		case "maxBackoff":
+			s.reconnect.maxBackoff, err = parsePositiveDuration(value)
+		case "ca":
+			var pem []byte
+			if pem, err = os.ReadFile(value); err == nil {
+				s.tls.RootCAs = x509.NewCertPool()
+				if !s.tls.RootCAs.AppendCertsFromPEM(pem) {
+					err = errors.New("no certificate")
+				}
+			}
+		case "cert":
+			certFile = value
+		case "key":
+			keyFile = value
+		case "serverName":
+			s.tls.ServerName = value
+		case "insecureSkipVerify":
+			s.tls.InsecureSkipVerify, err = strconv.ParseBool(value)
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %s: %w", s.output, key, err)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: cert: %w", s.output, err)
+		}
+		s.tls.Certificates = []tls.Certificate{cert}
+	}
+	s.spoolWarn = s.spoolLimit * warn / 100
+
+	path, defaultPath := s.spoolPath, s.spoolPath == ""
+	if defaultPath {
+		path = filepath.Join(os.TempDir(), "logger-"+spoolName.ReplaceAllString(s.addr, "_")+".spool")
+	}
+	path, err := filepath.Abs(path)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	if err == nil {
+		err = s.useSpool(path)
+	}
+	if errors.Is(err, errSpoolLocked) && defaultPath {
+		err = s.useSpool(strings.TrimSuffix(path, ".spool") + "-" + strconv.Itoa(os.Getpid()) + ".spool")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("output %s: spool: %w", s.output, err)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s, nil
+}
+
+// useSpool registers s as a user of the spool at path, and opens it unless
+// another sink of the process holds it.
+func (s *socketSink) useSpool(path string) error {
+	spoolUsers.Lock()
+	defer spoolUsers.Unlock()
+	s.spoolPath = path
+	if len(spoolUsers.m[path]) == 0 {
+		if err := s.openSpool(); err != nil {
+			return err
+		}
+	}
+	spoolUsers.m[path] = append(spoolUsers.m[path], s)
+	return nil
+}
+
+// openSpool opens and locks the spool.
+func (s *socketSink) openSpool() error {
+	f, err := os.OpenFile(s.spoolPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	err = lockSpool(f)
+	var info os.FileInfo
+	if err == nil {
+		info, err = f.Stat()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.spool, s.size = f, info.Size()
+	return nil
+}
+
+// takeOverSpool opens the spool closed by the sink that held it. The entries
+// kept in memory meanwhile are newer than the ones of the spool, so they're
+// moved to it unless they're being sent.
+func (s *socketSink) takeOverSpool() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.cond.Broadcast()
+	if err := s.openSpool(); err != nil {
+		reportOutputError(&OutputError{Output: s.output, Err: fmt.Errorf("spool: %w", err)})
+		return
+	}
+	// run only sends entries while connected
+	if s.connected || len(s.pending) == 0 || s.size+int64(s.bytes) > s.spoolLimit {
+		return
+	}
+	if _, err := s.spool.WriteAt(bytes.Join(s.pending, nil), s.size); err != nil {
+		return
+	}
+	s.size += int64(s.bytes)
+	s.pending, s.bytes = nil, 0
+}
+
+func (s *socketSink) setEncoder(_ string, config zapcore.EncoderConfig) {
+	s.enc = newEncoder("json", config)
+}
+
+// Write sends p, which should be a JSON object, as an InfoLevel entry.
+func (s *socketSink) Write(p []byte) (int, error) {
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now()}, Line: p}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *socketSink) writeRecord(r *record) error {
+	line := bytes.TrimSpace(r.Line)
+	if s.enc != nil {
+		buf, err := encodeRecord(s.enc, r, nil)
+		if err != nil {
+			return err
+		}
+		defer buf.Free()
+		line = bytes.TrimSpace(buf.Bytes())
+	} else if !json.Valid(line) {
+		line, _ = json.Marshal(map[string]string{"msg": string(line)})
+	}
+	s.enqueue(append(append([]byte(nil), line...), '\n'))
+	return nil
+}
+
+// enqueue keeps line in memory if the spool is empty and there's room left,
+// and appends it to the spool otherwise.
+func (s *socketSink) enqueue(line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.cond.Broadcast()
+	if s.size == 0 && s.bytes+len(line) <= socketBufferSize {
+		s.pending = append(s.pending, line)
+		s.bytes += len(line)
+		return
+	}
+	if s.spool == nil || s.size+int64(len(line)) > s.spoolLimit {
+		s.dropped++
+		return
+	}
+	if _, err := s.spool.WriteAt(line, s.size); err != nil {
+		s.dropped++
+		return
+	}
+	s.size += int64(len(line))
+	if !s.warned && s.size >= s.spoolWarn {
+		s.warned = true
+		reportOutputError(&OutputError{
+			Output: s.output,
+			Err:    fmt.Errorf("%w: %d of %d bytes", ErrSpoolFilling, s.size, s.spoolLimit),
+		})
+	}
+}
+
+// run sends the entries, reconnecting when needed, until the sink is closed.
+func (s *socketSink) run() {
+	defer close(s.done)
+	var conn net.Conn
+	for attempt := 0; ; {
+		s.reportDropped()
+		if conn == nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			var err error
+			if conn, err = s.dial(); err != nil {
+				// only the first failure of an outage is reported
+				if attempt == 0 {
+					reportOutputError(&OutputError{Output: s.output, Err: err})
+				}
+				select {
+				case <-time.After(s.reconnect.delay(attempt)):
+				case <-s.ctx.Done():
+					return
+				}
+				attempt++
+				continue
+			}
+			attempt = 0
+			s.setConnected(true)
+			// the agent doesn't send anything, reading only detects that it
+			// closed the connection, so that the next write fails
+			go func(conn net.Conn) {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			}(conn)
+		}
+
+		chunk, spooled, ok := s.next()
+		if !ok {
+			_ = conn.Close()
+			return
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		n, err := conn.Write(chunk)
+		s.sent(chunk, n, err == nil, spooled)
+		if err != nil {
+			_ = conn.Close()
+			conn = nil
+			s.setConnected(false)
+			reportOutputError(&OutputError{Output: s.output, Err: err})
+		}
+	}
+}
+
+// dial connects to the agent, and gives up when the sink is closed.
+func (s *socketSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	if s.tls != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tls}
+		return tlsDialer.DialContext(s.ctx, s.network, s.addr)
+	}
+	return dialer.DialContext(s.ctx, s.network, s.addr)
+}
+
+func (s *socketSink) setConnected(connected bool) {
+	s.mu.Lock()
+	s.connected = connected
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// next waits for entries to send and returns them, from the spool if they
+// come from it. It returns false when the sink is closed, once the entries in
+// memory are sent.
+func (s *socketSink) next() (chunk []byte, spooled bool, ok bool) {
+	s.mu.Lock()
+	for !s.closed && len(s.pending) == 0 && s.read == s.size {
+		s.cond.Wait()
+	}
+	if s.closed && len(s.pending) == 0 {
+		s.mu.Unlock()
+		return nil, false, false
+	}
+	if len(s.pending) > 0 {
+		chunk = bytes.Join(s.pending, nil)
+		s.mu.Unlock()
+		return chunk, false, true
+	}
+	read, size := s.read, s.size
+	s.mu.Unlock()
+
+	// the part of the spool being sent is only changed by sent
+	if size-read > socketBufferSize {
+		size = read + socketBufferSize
+	}
+	chunk = make([]byte, size-read)
+	n, err := s.spool.ReadAt(chunk, read)
+	if err != nil && n == 0 {
+		// there's no way to send the entries of a spool that can't be read
+		s.mu.Lock()
+		s.truncate()
+		s.mu.Unlock()
+		reportOutputError(&OutputError{Output: s.output, Err: fmt.Errorf("spool: %w", err)})
+		return nil, true, true
+	}
+	return chunk[:n], true, true
+}
+
+// sent removes the entries of chunk that were written, n bytes of it, or all
+// of it if complete. A line written partly is sent again.
+func (s *socketSink) sent(chunk []byte, n int, complete bool, spooled bool) {
+	if !complete {
+		n = bytes.LastIndexByte(chunk[:n], '\n') + 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.cond.Broadcast()
+	if spooled {
+		s.read += int64(n)
+		if s.read == s.size {
+			s.truncate()
+		}
+		return
+	}
+	for n > 0 && len(s.pending) > 0 && len(s.pending[0]) <= n {
+		n -= len(s.pending[0])
+		s.bytes -= len(s.pending[0])
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+	}
+}
+
+// truncate empties the spool once its entries are sent.
+func (s *socketSink) truncate() {
+	if err := s.spool.Truncate(0); err != nil {
+		return
+	}
+	s.read, s.size, s.warned = 0, 0, false
+}
+
+func (s *socketSink) reportDropped() {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		reportOutputError(&OutputError{Output: s.output, Dropped: dropped, Err: ErrSpoolFull})
+	}
+}
+
+// Sync waits until the entries are sent, or until the connection is lost,
+// and flushes the spool to disk.
+func (s *socketSink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.connected && !s.closed && (len(s.pending) > 0 || s.read < s.size) {
+		s.cond.Wait()
+	}
+	if s.spool == nil {
+		return nil
+	}
+	return s.spool.Sync()
+}
+
+// Close sends the entries kept in memory if the agent is connected, and
+// saves the ones left in the spool, for the next run or for the sink taking
+// it over.
+func (s *socketSink) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		s.cond.Broadcast()
+		s.cancel()
+	})
+	<-s.done
+	s.reportDropped()
+
+	spoolUsers.Lock()
+	defer spoolUsers.Unlock()
+	held, err := s.saveSpool()
+	users := spoolUsers.m[s.spoolPath]
+	for i, u := range users {
+		if u == s {
+			users = append(users[:i:i], users[i+1:]...)
+			break
+		}
+	}
+	if len(users) == 0 {
+		delete(spoolUsers.m, s.spoolPath)
+	} else {
+		spoolUsers.m[s.spoolPath] = users
+		if held {
+			users[0].takeOverSpool()
+		}
+	}
+	return err
+}
+
+// saveSpool saves the entries left in the spool and closes it, and returns
+// whether s held it.
+func (s *socketSink) saveSpool() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.spool == nil {
+		if len(s.pending) > 0 {
+			reportOutputError(&OutputError{Output: s.output, Dropped: len(s.pending), Err: errors.New("spool held by another output")})
+			s.pending, s.bytes = nil, 0
+		}
+		return false, nil
+	}
+	f := s.spool
+	s.spool = nil
+	if len(s.pending) == 0 && s.read == 0 {
+		if s.size == 0 {
+			_ = f.Close()
+			return true, os.Remove(s.spoolPath)
+		}
+		return true, f.Close()
+	}
+
+	// the entries in memory are older than the ones of the spool, which is
+	// rewritten with them first
+	tmp, err := os.CreateTemp(filepath.Dir(s.spoolPath), filepath.Base(s.spoolPath)+".*")
+	if err == nil {
+		_, err = tmp.Write(bytes.Join(s.pending, nil))
+		if err == nil {
+			_, err = io.Copy(tmp, io.NewSectionReader(f, s.read, s.size-s.read))
+		}
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), s.spoolPath)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}
+	_ = f.Close()
+	if err != nil {
+		return true, fmt.Errorf("output %s: spool: %w", s.output, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package logger
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockSpool locks the spool f, so that two processes don't send and rewrite
+// the same spool. The lock goes away with the file descriptor.
+func lockSpool(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errSpoolLocked
+	}
+	return err
+}
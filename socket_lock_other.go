@@ -0,0 +1,10 @@
+//go:build !unix
+
+package logger
+
+import "os"
+
+// lockSpool would lock the spool f, which is only supported on Unix.
+func lockSpool(*os.File) error {
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package logger
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestJSONLinesSpoolLocked(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	// another process holds the spools
+	spool := filepath.Join(dir, "agent.spool")
+	for _, path := range []string{spool, filepath.Join(dir, "logger-localhost_9000.spool")} {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := lockSpool(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u, _ := url.Parse("jsonl+tcp://localhost:9000?spool=" + spool)
+	if _, err := newSocketSink(u); !errors.Is(err, errSpoolLocked) {
+		t.Fatalf("err = %v", err)
+	}
+	// the default spool has a fallback
+	u, _ = url.Parse("jsonl+tcp://localhost:9000")
+	sink, err := newSocketSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if path := sink.(*socketSink).spoolPath; path != filepath.Join(dir, "logger-localhost_9000-"+strconv.Itoa(os.Getpid())+".spool") {
+		t.Fatalf("spool = %s", path)
+	}
+}
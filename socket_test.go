@@ -0,0 +1,257 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readJSONLines reads n lines from the next connection accepted by ln.
+func readJSONLines(t *testing.T, ln net.Listener, n int) (net.Conn, []map[string]interface{}) {
+	t.Helper()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var lines []map[string]interface{}
+	for i := 0; i < n; i++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return conn, lines
+}
+
+func TestJSONLinesReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	spool := filepath.Join(t.TempDir(), "agent.spool")
+	l := newOutputTestLogger(t, "jsonl+tcp://"+addr+"?backoff=10ms&maxBackoff=50ms&spool="+spool)
+
+	l.Infow("one", "i", 1)
+	conn, lines := readJSONLines(t, ln, 1)
+	if lines[0]["msg"] != "one" || lines[0]["i"] != 1.0 {
+		t.Fatalf("lines = %v", lines)
+	}
+
+	// the agent restarts
+	conn.Close()
+	ln.Close()
+	time.Sleep(100 * time.Millisecond)
+	l.Info("two")
+	l.Info("three")
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skip("address not available again:", err)
+	}
+	defer ln.Close()
+	l.Info("four")
+
+	conn, lines = readJSONLines(t, ln, 3)
+	defer conn.Close()
+	var msgs []string
+	for _, line := range lines {
+		msgs = append(msgs, line["msg"].(string))
+	}
+	if strings.Join(msgs, ",") != "two,three,four" {
+		t.Fatalf("messages = %v", msgs)
+	}
+}
+
+func TestJSONLinesSpool(t *testing.T) {
+	for len(diagnostics) > 0 {
+		<-diagnostics
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	spool := filepath.Join(t.TempDir(), "agent.spool")
+	u, _ := url.Parse("jsonl+tcp://" + addr + "?backoff=1h&spoolSize=64KB&spoolWarn=50&spool=" + spool)
+	sink, err := newSocketSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more than what's kept in memory and fits in the spool
+	padding := strings.Repeat("x", 1000)
+	for i := 0; i < 400; i++ {
+		fmt.Fprintf(sink, `{"i":%d,"padding":%q}`, i, padding)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var filling, dropped bool
+	for len(diagnostics) > 0 {
+		e := <-diagnostics
+		filling = filling || errors.Is(e, ErrSpoolFilling)
+		dropped = dropped || errors.Is(e, ErrSpoolFull) && e.Dropped > 0
+	}
+	if !filling || !dropped {
+		t.Errorf("filling = %v, dropped = %v", filling, dropped)
+	}
+	info, err := os.Stat(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int(info.Size()) / (len(padding) + 25)
+
+	// the entries left are sent first on the next run
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skip("address not available again:", err)
+	}
+	defer ln.Close()
+	if sink, err = newSocketSink(u); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	conn, lines := readJSONLines(t, ln, n)
+	defer conn.Close()
+	for i, line := range lines {
+		if line["i"] != float64(i) {
+			t.Fatalf("line %d is entry %v", i, line["i"])
+		}
+	}
+}
+
+func TestJSONLinesSpoolReload(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	spool := filepath.Join(t.TempDir(), "agent.spool")
+	config := NewProductionConfig()
+	config.OutputPaths = []string{"jsonl+tcp://" + addr + "?backoff=20ms&maxBackoff=20ms&spool=" + spool}
+	config.Sampling = nil
+	l, err := NewLoggerE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("one")
+	l.Info("two")
+	// the new output waits for the spool, which the old one saves its entries
+	// to when it's closed
+	nl, err := l.(*logger).reload(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = nl.reload(NewProductionConfig()) }()
+	l.Info("three")
+
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skip("address not available again:", err)
+	}
+	defer ln.Close()
+	conn, lines := readJSONLines(t, ln, 3)
+	defer conn.Close()
+	var msgs []string
+	for _, line := range lines {
+		msgs = append(msgs, line["msg"].(string))
+	}
+	if strings.Join(msgs, ",") != "one,two,three" {
+		t.Fatalf("messages = %v", msgs)
+	}
+}
+
+func TestJSONLinesCloseSendsMemory(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	spool := filepath.Join(t.TempDir(), "agent.spool")
+	u, _ := url.Parse("jsonl+tcp://" + ln.Addr().String() + "?spool=" + spool)
+	sink, err := newSocketSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(sink, `{"i":%d}`, i)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries were sent rather than saved
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, _ := io.ReadAll(conn)
+	if n := strings.Count(string(data), "\n"); n != 100 {
+		t.Fatalf("%d entries sent", n)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Fatalf("spool left: %v", err)
+	}
+}
+
+func TestJSONLinesCloseEndsDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the agent accepts the connection but never answers the TLS handshake
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	spool := filepath.Join(t.TempDir(), "agent.spool")
+	u, _ := url.Parse("jsonl+tls://" + ln.Addr().String() + "?timeout=1h&spool=" + spool)
+	sink, err := newSocketSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection")
+	}
+	closeWithin(t, sink, 2*time.Second)
+}
+
+func TestJSONLinesURLErrors(t *testing.T) {
+	for _, path := range []string{
+		"jsonl+tcp://",
+		"jsonl+tcp://localhost:9000?serverName=agent",
+		"jsonl+tls://localhost:9000?ca=/nonexistent.pem",
+		"jsonl+unix:///tmp/agent.sock?spoolWarn=120",
+		"jsonl+tcp://localhost:9000?spoolSize=0",
+	} {
+		u, _ := url.Parse(path)
+		if _, err := newSocketSink(u); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The syslog outputs send entries to a syslog daemon:
+//
+//	syslog+unix:///dev/log
+//	syslog+udp://loghost:514?facility=local0&app=api
+//	syslog+tcp://loghost:514?format=rfc3164&framing=lf
+//
+// The query parameters are:
+//
+//   - facility: the facility name, such as "daemon" or "local0", "user" by
+//     default
+//   - app: the APP-NAME or TAG, the name of the executable by default
+//   - format: "rfc5424" (default) or "rfc3164"
+//   - framing: over TCP and unix stream sockets, "octet" (default) prefixes
+//     every message with its length as in RFC 6587, "lf" ends it with a
+//     newline instead
+//   - sdid: the SD-ID of the structured data, "fields@32473" by default
+//
+// With RFC 5424 the message is the entry message and the fields of the entry
+// are sent as structured data. With RFC 3164, which has no structured data,
+// the message is the entry as encoded for the output, without colors. Levels
+// are mapped to the syslog severities, DPanicLevel to critical, PanicLevel to
+// alert and FatalLevel to emergency.
+//
+// The connection is opened on the first entry and opened again when writing
+// fails.
+const (
+	SyslogUnixScheme = "syslog+unix"
+	SyslogUDPScheme  = "syslog+udp"
+	SyslogTCPScheme  = "syslog+tcp"
+)
+
+const defaultSyslogPort = "514"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func init() {
+	for _, scheme := range []string{SyslogUnixScheme, SyslogUDPScheme, SyslogTCPScheme} {
+		registerRecordSink(scheme, newSyslogSink)
+	}
+}
+
+// syslogTimeout bounds connecting to the daemon and writing a message to a
+// stream connection. Tests shorten it.
+var syslogTimeout = 5 * time.Second
+
+type syslogSink struct {
+	network   string
+	addr      string
+	facility  int
+	appName   string
+	rfc3164   bool
+	lfFraming bool
+	sdID      string
+	enc       zapcore.Encoder // of the RFC 3164 messages, nil if opened through zap
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool // conn is a stream connection
+}
+
+func newSyslogSink(u *url.URL) (recordSink, error) {
+	s := &syslogSink{
+		network:  strings.TrimPrefix(u.Scheme, "syslog+"),
+		facility: syslogFacilities["user"],
+		appName:  syslogName(filepath.Base(os.Args[0]), 48),
+		sdID:     "fields@32473",
+	}
+	switch s.network {
+	case "unix":
+		s.addr = u.Path
+		if s.addr == "" {
+			s.addr = "/dev/log"
+		}
+	default:
+		s.addr = u.Host
+		if s.addr == "" {
+			return nil, fmt.Errorf("syslog URL without a host: %s", u)
+		}
+		if _, _, err := net.SplitHostPort(s.addr); err != nil {
+			s.addr = net.JoinHostPort(u.Hostname(), defaultSyslogPort)
+		}
+	}
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		var err error
+		switch key {
+		case "facility":
+			f, ok := syslogFacilities[strings.ToLower(value)]
+			if !ok {
+				err = errors.New("unknown facility")
+			}
+			s.facility = f
+		case "app":
+			s.appName = syslogName(value, 48)
+		case "format":
+			switch value {
+			case "rfc5424", "rfc3164":
+				s.rfc3164 = value == "rfc3164"
+			default:
+				err = errors.New(`want "rfc5424" or "rfc3164"`)
+			}
+		case "framing":
+			switch value {
+			case "octet", "lf":
+				s.lfFraming = value == "lf"
+			default:
+				err = errors.New(`want "octet" or "lf"`)
+			}
+		case "sdid":
+			s.sdID = syslogName(value, 32)
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("syslog URL %s: %s: %w", u, key, err)
+		}
+	}
+	return s, nil
+}
+
+// setEncoder encodes the RFC 3164 messages as the output does, without
+// colors.
+func (s *syslogSink) setEncoder(encoding string, config zapcore.EncoderConfig) {
+	s.enc = newEncoder(encoding, config)
+}
+
+// Write sends p as an InfoLevel message.
+func (s *syslogSink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	r := &record{Entry: zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: msg}, Line: p}
+	if err := s.writeRecord(r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogSink) writeRecord(r *record) error {
+	msg, err := s.format(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a connection that was closed by the daemon in the meantime only fails
+	// on the write, so it's retried once on a new connection
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				return err
+			}
+		}
+		if s.stream {
+			// a daemon that stops reading would block every log call
+			_ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		}
+		if _, err = s.conn.Write(s.frame(msg)); err == nil {
+			return nil
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	if s.network != "unix" {
+		s.stream = s.network == "tcp"
+		return net.DialTimeout(s.network, s.addr, syslogTimeout)
+	}
+	// the local daemon usually listens on a datagram socket
+	conn, err := net.Dial("unixgram", s.addr)
+	if err == nil {
+		s.stream = false
+		return conn, nil
+	}
+	s.stream = true
+	return net.Dial("unix", s.addr)
+}
+
+// frame returns msg framed for the connection: datagrams hold one message
+// each, streams need the messages to be delimited.
+func (s *syslogSink) frame(msg []byte) []byte {
+	switch {
+	case !s.stream:
+		return msg
+	case s.lfFraming:
+		return append(msg, '\n')
+	}
+	return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+}
+
+func (s *syslogSink) Sync() error {
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// format returns r as a syslog message, without framing.
+func (s *syslogSink) format(r *record) ([]byte, error) {
+	pri := s.facility*8 + syslogSeverity(r.Level)
+	pid := os.Getpid()
+	if s.rfc3164 {
+		line := r.Line
+		if s.enc != nil {
+			buf, err := encodeRecord(s.enc, r, nil)
+			if err != nil {
+				return nil, err
+			}
+			defer buf.Free()
+			line = buf.Bytes()
+		}
+		return []byte(fmt.Sprintf("<%d>%s %s %s[%d]: %s",
+			pri, r.Time.Format(time.Stamp), syslogName(hostname, 255), s.appName, pid,
+			bytes.TrimSuffix(line, []byte("\n")))), nil
+	}
+
+	msgID := "-"
+	if r.LoggerName != "" {
+		msgID = syslogName(r.LoggerName, 32)
+	}
+	msg := r.Message
+	if r.Stack != "" {
+		msg += "\n" + r.Stack
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		pri, r.Time.Format("2006-01-02T15:04:05.000000Z07:00"), syslogName(hostname, 255),
+		s.appName, pid, msgID, s.structuredData(r.Fields), msg)), nil
+}
+
+// structuredData returns fields as an SD-ELEMENT, sorted by name.
+func (s *syslogSink) structuredData(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("[" + s.sdID)
+	for _, k := range names {
+		value := syslogParamEscaper.Replace(recordValue(fields[k]))
+		fmt.Fprintf(&b, ` %s="%s"`, syslogParamName(k), value)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogName returns s with the characters that aren't printable ASCII
+// replaced, cut to max bytes; "-" when empty.
+func syslogName(s string, max int) string {
+	name := []byte(s)
+	if len(name) > max {
+		name = name[:max]
+	}
+	for i, c := range name {
+		if c < 33 || c > 126 {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "-"
+	}
+	return string(name)
+}
+
+// syslogParamName returns k as a PARAM-NAME.
+func syslogParamName(k string) string {
+	name := []byte(syslogName(k, 32))
+	for i, c := range name {
+		if c == '=' || c == ']' || c == '"' {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func syslogSeverity(lvl zapcore.Level) int {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := newOutputTestLogger(t, "syslog+udp://"+conn.LocalAddr().String()+"?facility=local3&app=api")
+	l.Warnw("disk full", "disk", "sda", "free", 3, "note", `a "quoted] \value`)
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	// local3 * 8 + warning
+	if !strings.HasPrefix(got, "<156>1 ") || !strings.Contains(got, " api ") ||
+		!strings.Contains(got, `[fields@32473 disk="sda" free="3" note="a \"quoted\] \\value"]`) ||
+		!strings.HasSuffix(got, " disk full") {
+		t.Fatalf("message = %q", got)
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	l := newOutputTestLogger(t, "syslog+tcp://"+ln.Addr().String())
+	l.Info("first")
+	l.Errorw("second\nline", "k", "v")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var msgs []string
+	for i := 0; i < 2; i++ {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+		if err != nil {
+			t.Fatalf("bad frame length %q", size)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(msg))
+	}
+	if !strings.HasPrefix(msgs[0], "<14>1 ") || !strings.HasSuffix(msgs[0], " - first") {
+		t.Fatalf("first message = %q", msgs[0])
+	}
+	if !strings.HasPrefix(msgs[1], "<11>1 ") || !strings.Contains(msgs[1], `[fields@32473 k="v"] second`+"\nline\n") {
+		t.Fatalf("second message = %q", msgs[1])
+	}
+}
+
+func TestSyslogTCPWriteTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the daemon accepts the connection but never reads from it
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(10 * time.Second)
+		}
+	}()
+	defer func(timeout time.Duration) { syslogTimeout = timeout }(syslogTimeout)
+	syslogTimeout = 100 * time.Millisecond
+
+	u, _ := url.Parse("syslog+tcp://" + ln.Addr().String())
+	sink, err := newSyslogSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a write blocked on the full connection gives up and moves on to a new
+	// one, so the writes keep returning
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sink.Close()
+		msg := []byte(strings.Repeat("x", 64<<10))
+		for start := time.Now(); time.Since(start) < time.Second; {
+			_, _ = sink.Write(msg)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a write is blocked")
+	}
+}
+
+func TestSyslogUnixRFC3164(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	l := newOutputTestLogger(t, "syslog+unix://"+path+"?format=rfc3164&facility=daemon&app=worker")
+	l.Infow("started", "jobs", 4)
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "<30>") || !strings.Contains(got, " worker[") ||
+		!strings.Contains(got, `"msg":"started","jobs":4}`) {
+		t.Fatalf("message = %q", got)
+	}
+}
+
+func TestSyslogRFC3164WithoutColors(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := newOutputTestLogger(t, "syslog+udp://"+conn.LocalAddr().String()+"?format=rfc3164", func(config *Config) {
+		config.Encoding = "console"
+		config.EnableColor = true
+	})
+	l.Warnw("colored", "k", "v")
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if strings.Contains(got, "\x1b[") || !strings.Contains(got, "\twarn\t") || !strings.Contains(got, `{"k": "v"}`) {
+		t.Fatalf("message = %q", got)
+	}
+}
+
+func TestSyslogURL(t *testing.T) {
+	for _, u := range []string{
+		"syslog+udp://localhost?facility=nope",
+		"syslog+udp://localhost?format=json",
+		"syslog+tcp://localhost?nope=1",
+		"syslog+tcp:///dev/log",
+	} {
+		if _, err := openRecordSink(u); err == nil {
+			t.Errorf("%s: expected error", u)
+		}
+	}
+	s, err := openRecordSink("syslog+udp://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := s.(*syslogSink).addr; addr != "localhost:514" {
+		t.Fatalf("addr = %s", addr)
+	}
+}
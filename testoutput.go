@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestingScheme is the scheme of the outputs of NewTestConfig, which write
+// the entries to a test with t.Log.
+const TestingScheme = "testing"
+
+var (
+	testsMu sync.Mutex
+	// tests are the tests written by the testing outputs, by id, until they
+	// end.
+	tests  = make(map[string]testing.TB)
+	testID int
+)
+
+func init() {
+	if err := zap.RegisterSink(TestingScheme, newTestingSink); err != nil {
+		panic(err)
+	}
+}
+
+// NewTestConfig returns the config of the "test" preset, with its entries and
+// errors written to t with t.Log: go test shows them with the test, when it
+// fails or with -v. The entries logged once t has ended are discarded.
+func NewTestConfig(t testing.TB, fields ...FieldPair) *Config {
+	config, _ := NewConfigFromPreset("test", fields...)
+	output := testingOutput(t)
+	config.OutputPaths = []string{output}
+	config.ErrorOutputPaths = []string{output}
+	return config
+}
+
+// testingOutput returns the URL of a testing output writing to t.
+func testingOutput(t testing.TB) string {
+	testsMu.Lock()
+	testID++
+	id := strconv.Itoa(testID)
+	tests[id] = t
+	testsMu.Unlock()
+	t.Cleanup(func() {
+		testsMu.Lock()
+		delete(tests, id)
+		testsMu.Unlock()
+	})
+	return TestingScheme + "://" + id
+}
+
+type testingSink struct {
+	id string
+}
+
+func newTestingSink(u *url.URL) (zap.Sink, error) {
+	if u.Host == "" {
+		return nil, errors.New("testing outputs are created by NewTestConfig")
+	}
+	return testingSink{id: u.Host}, nil
+}
+
+func (s testingSink) Write(p []byte) (int, error) {
+	// logging from a test that has ended panics
+	testsMu.Lock()
+	defer testsMu.Unlock()
+	if t, ok := tests[s.id]; ok {
+		t.Log(strings.TrimSuffix(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+func (testingSink) Sync() error {
+	return nil
+}
+
+func (testingSink) Close() error {
+	return nil
+}
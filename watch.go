@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often WatchConfigFile polls when it's given a
+// non-positive interval.
+const DefaultWatchInterval = 5 * time.Second
+
+// ConfigWatcher reloads the global logger when its config file changes.
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	onError  func(error)
+	seen     []byte
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// WatchConfigFile loads the config file at path (see LoadConfigFile) into the
+// global logger, then checks the file every interval and reloads the global
+// logger whenever its content changes. Loggers already derived from the global
+// logger with With, Ctx or WithTraceID pick up the new level, encoding,
+// outputs and initial fields.
+//
+// A reload that fails keeps the current logger in place and reports the error
+// to onError, or to stderr when onError is nil. Only the initial load error is
+// returned.
+//
+// The file is polled rather than watched with inotify, because mounted
+// Kubernetes ConfigMaps are updated by swapping symlinks, which inotify
+// watches don't survive.
+func WatchConfigFile(path string, interval time.Duration, onError func(error)) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if onError == nil {
+		onError = func(err error) {
+			fmt.Fprintf(os.Stderr, "error on reload logger config (%s)\n", err)
+		}
+	}
+	w := &ConfigWatcher{
+		path:     path,
+		interval: interval,
+		onError:  onError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Stop stops watching the file. The global logger keeps its current config.
+func (w *ConfigWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *ConfigWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.reload(); err != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// reload applies the file to the global logger if its content changed since
+// the last check, so a broken file is reported once rather than every tick.
+func (w *ConfigWatcher) reload() error {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if w.seen != nil && bytes.Equal(data, w.seen) {
+		return nil
+	}
+	w.seen = data
+
+	config, err := ParseConfig(data, filepath.Ext(w.path))
+	if err != nil {
+		return fmt.Errorf("load config %s: %w", w.path, err)
+	}
+	if err := SetConfigE(config); err != nil {
+		return fmt.Errorf("reload config %s: %w", w.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSetConfigReloadsDerivedLoggers(t *testing.T) {
+	keepGlobal(t)
+	dir := t.TempDir()
+	before, after := filepath.Join(dir, "before.log"), filepath.Join(dir, "after.log")
+
+	config := NewProductionConfig()
+	config.OutputPaths = []string{before}
+	SetConfig(config)
+
+	child := With("child", "yes")
+	ctxLogger := child.Ctx(context.Background())
+	child.Info("first")
+
+	config = NewProductionConfig(FieldPair{"reloaded", "true"})
+	config.Level = WarnLevel
+	config.OutputPaths = []string{after}
+	SetConfig(config)
+
+	child.Info("filtered by the new level")
+	child.Warn("second")
+	ctxLogger.Warn("third")
+
+	if got := readLog(t, before); !strings.Contains(got, "first") || strings.Contains(got, "second") {
+		t.Fatalf("before.log = %q", got)
+	}
+	got := readLog(t, after)
+	if strings.Contains(got, "filtered") {
+		t.Fatalf("after.log has entry below the new level: %q", got)
+	}
+	for _, want := range []string{"second", "third", `"child":"yes"`, `"reloaded":"true"`} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("after.log = %q, want %s", got, want)
+		}
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	keepGlobal(t)
+	dir := t.TempDir()
+	path, out := filepath.Join(dir, "logger.yaml"), filepath.Join(dir, "out.log")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("level: info\noutputPaths: [stderr]\n")
+
+	errs := make(chan error, 1)
+	w, err := WatchConfigFile(path, 10*time.Millisecond, func(err error) { errs <- err })
+	if err != nil {
+		t.Fatalf("WatchConfigFile: %v", err)
+	}
+	defer w.Stop()
+	child := With("child", "yes")
+
+	writeConfig("level: warn\noutputPaths: [" + out + "]\n")
+	waitFor(t, func() bool { return GetLevel() == WarnLevel })
+	child.Warn("after reload")
+	if got := readLog(t, out); !strings.Contains(got, "after reload") {
+		t.Fatalf("out.log = %q", got)
+	}
+
+	writeConfig("level: loud\n")
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "loud") {
+			t.Fatalf("unexpected reload error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reload error not reported")
+	}
+	if GetLevel() != WarnLevel {
+		t.Fatalf("failed reload changed the level to %v", GetLevel())
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -7,108 +7,112 @@ import (
 )
 
 func Debug(args ...interface{}) {
-	l.Debug(args...)
+	current().ext.Debug(args...)
 }
 
 func Info(args ...interface{}) {
-	l.Info(args...)
+	current().ext.Info(args...)
 }
 
 func Warn(args ...interface{}) {
-	l.Warn(args...)
+	current().ext.Warn(args...)
 }
 
 func Error(args ...interface{}) {
-	l.Error(args...)
+	current().ext.Error(args...)
 }
 
 func DPanic(args ...interface{}) {
-	l.DPanic(args...)
+	current().ext.DPanic(args...)
 }
 
 func Panic(args ...interface{}) {
-	l.Panic(args...)
+	current().ext.Panic(args...)
 }
 
 func Fatal(args ...interface{}) {
-	l.Fatal(args...)
+	current().ext.Fatal(args...)
 }
 
 func Debugf(template string, args ...interface{}) {
-	l.Debugf(template, args...)
+	current().ext.Debugf(template, args...)
 }
 
 func Infof(template string, args ...interface{}) {
-	l.Infof(template, args...)
+	current().ext.Infof(template, args...)
 }
 
 func Warnf(template string, args ...interface{}) {
-	l.Warnf(template, args...)
+	current().ext.Warnf(template, args...)
 }
 
 func Errorf(template string, args ...interface{}) {
-	l.Errorf(template, args...)
+	current().ext.Errorf(template, args...)
 }
 
 func DPanicf(template string, args ...interface{}) {
-	l.DPanicf(template, args...)
+	current().ext.DPanicf(template, args...)
 }
 
 func Panicf(template string, args ...interface{}) {
-	l.Panicf(template, args...)
+	current().ext.Panicf(template, args...)
 }
 
 func Fatalf(template string, args ...interface{}) {
-	l.Fatalf(template, args...)
+	current().ext.Fatalf(template, args...)
 }
 
 func Debugw(msg string, keysAndValues ...interface{}) {
-	l.Debugw(msg, keysAndValues...)
+	current().ext.Debugw(msg, keysAndValues...)
 }
 
 func Infow(msg string, keysAndValues ...interface{}) {
-	l.Infow(msg, keysAndValues...)
+	current().ext.Infow(msg, keysAndValues...)
 }
 
 func Warnw(msg string, keysAndValues ...interface{}) {
-	l.Warnw(msg, keysAndValues...)
+	current().ext.Warnw(msg, keysAndValues...)
 }
 
 func Errorw(msg string, keysAndValues ...interface{}) {
-	l.Errorw(msg, keysAndValues...)
+	current().ext.Errorw(msg, keysAndValues...)
 }
 
 func DPanicw(msg string, keysAndValues ...interface{}) {
-	l.DPanicw(msg, keysAndValues...)
+	current().ext.DPanicw(msg, keysAndValues...)
 }
 
 func Panicw(msg string, keysAndValues ...interface{}) {
-	l.Panicw(msg, keysAndValues...)
+	current().ext.Panicw(msg, keysAndValues...)
 }
 
 func Fatalw(msg string, keysAndValues ...interface{}) {
-	l.Fatalw(msg, keysAndValues...)
+	current().ext.Fatalw(msg, keysAndValues...)
 }
 
 func Sync() error {
-	return l.Sync()
+	return current().ext.Sync()
 }
 
 func With(keyValues ...interface{}) Logger {
+	g := current()
 	if len(keyValues) == 0 {
-		return l
+		return g.Logger
 	}
-	return l.WithCallerSkip(context.Background(), defaultCallerSkip, l.tracing, keyValues...)
+	if g.l == nil {
+		return g.With(keyValues...)
+	}
+	return g.l.WithCallerSkip(context.Background(), defaultCallerSkip, g.l.tracing, keyValues...)
 }
 
 func WithTraceID(ctx context.Context, keyValues ...interface{}) Logger {
-	return l.WithTraceID(ctx, keyValues...)
+	return current().ext.WithTraceID(ctx, keyValues...)
 }
 
 func Ctx(ctx context.Context) Logger {
-	return l.Ctx(ctx)
+	return current().ext.Ctx(ctx)
 }
 
 func GetZapLogger() *zap.Logger {
-	return l.GetZapLogger()
+	return current().ext.GetZapLogger()
 }